@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Signed requests carry these headers. The signature is the hex HMAC-SHA256,
+// keyed with SigningKey(secret), of the canonical request:
+//
+//	METHOD \n PATH?QUERY \n TIMESTAMP \n NONCE \n hex(SHA-256(body))
+const (
+	HeaderAPIKey    = "X-API-Key"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+// SignatureWindow is how far a request timestamp may drift from server time.
+// Nonces are remembered for the same window.
+const SignatureWindow = 5 * time.Minute
+
+var (
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrInvalidSignature  = errors.New("invalid request signature")
+	ErrStaleRequest      = errors.New("request timestamp outside allowed window")
+	ErrReplayedRequest   = errors.New("request nonce already used")
+	ErrInvalidScope      = errors.New("invalid scope")
+	ErrNotServiceAccount = errors.New("api keys can only be issued to game server accounts")
+)
+
+// apiKeyScopes are the permissions an API key may carry
+var apiKeyScopes = []Permission{PermScoresRead, PermScoresWrite}
+
+type APIKey struct {
+	KeyID              string       `json:"key_id"`
+	AccID              int          `json:"acc_id"`
+	Name               string       `json:"name"`
+	Scopes             []Permission `json:"scopes"`
+	RateLimitPerMinute int          `json:"rate_limit_per_minute"`
+	CreatedAt          time.Time    `json:"created_at"`
+	LastUsedAt         *time.Time   `json:"last_used_at,omitempty"`
+	RevokedAt          *time.Time   `json:"revoked_at,omitempty"`
+
+	signingKey []byte
+}
+
+// HasScope reports whether the key grants a permission.
+func (k *APIKey) HasScope(perm Permission) bool {
+	for _, s := range k.Scopes {
+		if s == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SigningKey derives the HMAC key for a secret. Clients compute the same value
+// from the secret they were given.
+func SigningKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// secretVerifier is what secret_hash holds for a signing key. The signing key
+// itself is stored sealed with the key ring, so reading api_keys is not
+// enough to sign requests.
+func secretVerifier(signingKey []byte) []byte {
+	sum := sha256.Sum256(signingKey)
+	return sum[:]
+}
+
+// CreateAPIKey issues a key for a game server account. The secret is returned
+// once and cannot be recovered afterwards.
+func CreateAPIKey(db *sql.DB, accID int, name string, scopes []Permission, rateLimit int) (*APIKey, string, error) {
+	for _, scope := range scopes {
+		valid := false
+		for _, s := range apiKeyScopes {
+			if scope == s {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	role, err := GetUserRole(db, accID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrNotServiceAccount
+		}
+		return nil, "", fmt.Errorf("error reading account role: %v", err)
+	}
+	if role != RoleGameServer {
+		return nil, "", ErrNotServiceAccount
+	}
+
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, "", fmt.Errorf("error generating key id: %v", err)
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("error generating key secret: %v", err)
+	}
+
+	key := &APIKey{
+		KeyID:              "gk_" + id,
+		AccID:              accID,
+		Name:               name,
+		Scopes:             scopes,
+		RateLimitPerMinute: rateLimit,
+	}
+	signingKey := SigningKey(secret)
+	ringKeyID, sealed, err := sealSigningKey(key.KeyID, signingKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("error encrypting api key: %w", err)
+	}
+	err = db.QueryRow(`
+		INSERT INTO api_keys (key_id, acc_id, name, secret_hash, signing_key_sealed, signing_key_id,
+			scopes, rate_limit_per_minute)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at
+	`, key.KeyID, accID, name, secretVerifier(signingKey), sealed, ringKeyID,
+		pq.Array(scopeStrings(scopes)), rateLimit).Scan(&key.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating api key: %v", err)
+	}
+
+	return key, secret, nil
+}
+
+func scopeStrings(scopes []Permission) []string {
+	out := make([]string, len(scopes))
+	for i, s := range scopes {
+		out[i] = string(s)
+	}
+	return out
+}
+
+const apiKeyColumns = `key_id, acc_id, name, scopes, rate_limit_per_minute, created_at, last_used_at, revoked_at`
+
+// scanAPIKey scans apiKeyColumns, followed by any extra columns into extra.
+func scanAPIKey(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*APIKey, error) {
+	var key APIKey
+	var scopes []string
+	dest := append([]interface{}{&key.KeyID, &key.AccID, &key.Name, pq.Array(&scopes),
+		&key.RateLimitPerMinute, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	for _, s := range scopes {
+		key.Scopes = append(key.Scopes, Permission(s))
+	}
+	return &key, nil
+}
+
+func ListAPIKeys(db *sql.DB) ([]APIKey, error) {
+	rows, err := db.Query(`SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying api keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning api key: %v", err)
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func RevokeAPIKey(db *sql.DB, keyID string) error {
+	result, err := db.Exec("UPDATE api_keys SET revoked_at = NOW() WHERE key_id = $1 AND revoked_at IS NULL", keyID)
+	if err != nil {
+		return fmt.Errorf("error revoking api key: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// SignRequest returns the signature for a request. Game server clients use
+// the same construction.
+func SignRequest(signingKey []byte, method, path, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(strings.Join([]string{method, path, timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedRequest is the signature material of an API key request.
+type SignedRequest struct {
+	KeyID     string
+	Timestamp string
+	Nonce     string
+	Signature string
+	Method    string
+	Path      string
+	Body      []byte
+}
+
+// VerifySignedRequest authenticates a request signed with an API key. The
+// nonce is recorded only once the signature checks out, so forged requests
+// cannot burn a legitimate client's nonces.
+func VerifySignedRequest(db *sql.DB, req SignedRequest) (*APIKey, error) {
+	var ringKeyID string
+	var sealed []byte
+	key, err := scanAPIKey(db.QueryRow(`
+		SELECT `+apiKeyColumns+`, signing_key_id, signing_key_sealed
+		FROM api_keys
+		WHERE key_id = $1 AND revoked_at IS NULL
+	`, req.KeyID), &ringKeyID, &sealed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("error reading api key: %v", err)
+	}
+	if key.signingKey, err = openSigningKey(key.KeyID, ringKeyID, sealed); err != nil {
+		return nil, fmt.Errorf("error reading api key: %w", err)
+	}
+
+	ts, err := strconv.ParseInt(req.Timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrStaleRequest
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > SignatureWindow || skew < -SignatureWindow {
+		return nil, ErrStaleRequest
+	}
+
+	if len(req.Nonce) < 16 || len(req.Nonce) > 64 {
+		return nil, ErrInvalidSignature
+	}
+
+	expected := SignRequest(key.signingKey, req.Method, req.Path, req.Timestamp, req.Nonce, req.Body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(req.Signature))) {
+		return nil, ErrInvalidSignature
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO api_key_nonces (key_id, nonce) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, key.KeyID, req.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error recording nonce: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrReplayedRequest
+	}
+
+	if _, err := db.Exec("UPDATE api_keys SET last_used_at = NOW() WHERE key_id = $1", key.KeyID); err != nil {
+		log.Printf("Failed to record use of api key %s: %v", key.KeyID, err)
+	}
+	return key, nil
+}
+
+// DeleteExpiredNonces drops nonces older than the signature window; requests
+// reusing them are rejected by the timestamp check instead.
+func DeleteExpiredNonces(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM api_key_nonces WHERE created_at < NOW() - $1::interval",
+		fmt.Sprintf("%d seconds", int(2*SignatureWindow/time.Second)))
+	return err
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+// Audit outcomes
+const (
+	AuditDenied  = "denied"
+	AuditAllowed = "allowed"
+	AuditFailed  = "failed"
+)
+
+// AuditEvent is a row in audit_log. AccID is 0 when the caller is unknown.
+type AuditEvent struct {
+	AccID    int
+	Action   string
+	Resource string
+	Outcome  string
+	Reason   string
+	ClientIP string
+	Metadata map[string]interface{}
+}
+
+func RecordAudit(db *sql.DB, event AuditEvent) error {
+	var metadata interface{}
+	if len(event.Metadata) > 0 {
+		data, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return err
+		}
+		metadata = string(data)
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO audit_log (acc_id, action, resource, outcome, reason, client_ip, metadata)
+		VALUES (NULLIF($1, 0), $2, NULLIF($3, ''), $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+	`, event.AccID, event.Action, event.Resource, event.Outcome, event.Reason, event.ClientIP, metadata)
+	return err
+}
+
+// audit records an event without failing the request it belongs to.
+func audit(db *sql.DB, event AuditEvent) {
+	if err := RecordAudit(db, event); err != nil {
+		log.Printf("Failed to record audit event %s: %v", event.Action, err)
+	}
+}
@@ -8,12 +8,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
-	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha1"
-	"encoding/base32"
-	"encoding/binary"
-	"strconv"
 )
 
 var jwtKey []byte
@@ -22,6 +17,18 @@ func InitJWTKey(secret string) {
 	jwtKey = []byte(secret)
 }
 
+// Lifetimes of issued tokens and sessions, set by SetExpiry
+var (
+	tokenExpiry = SessionExpiry5Min
+	sessionTTL  = SessionExpiry5Min
+)
+
+// SetExpiry sets how long newly issued tokens and sessions last.
+func SetExpiry(token, session time.Duration) {
+	tokenExpiry = token
+	sessionTTL = session
+}
+
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
@@ -53,6 +60,22 @@ const (
 	// SessionExpiry1Hour = 1 * time.Hour
 )
 
+// Account roles stored in accounts.role
+const (
+	RolePlayer     = "player"
+	RoleGameServer = "game_server"
+	RoleAdmin      = "admin"
+)
+
+func GetUserRole(db *sql.DB, userID int) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM accounts WHERE acc_id = $1", userID).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	return string(bytes), err
@@ -64,7 +87,7 @@ func CheckPasswordHash(password, hash string) bool {
 }
 
 func GenerateToken(user User) (string, error) {
-	expiryTime := time.Now().Add(SessionExpiry5Min)
+	expiryTime := time.Now().Add(tokenExpiry)
 	claims := &Claims{
 		UserID:   user.ID,
 		Username: user.Username,
@@ -162,91 +185,64 @@ func AuthenticateUser(db *sql.DB, username, password string) (*User, error) {
 	return user, nil
 }
 
-func GenerateSecret() (string, error) {
-	// Generate a random 20-byte secret
-	secret := make([]byte, 20)
-	_, err := rand.Read(secret)
+// Enable2FA turns on 2FA within tx with the secret and parameters the user
+// enrolled with, and returns a new set of recovery codes. step is the time
+// step of the code that confirmed enrollment, which cannot then be used to
+// log in.
+func Enable2FA(tx *sql.Tx, userID int, secret string, params TOTPParams, step int64) ([]string, error) {
+	keyID, sealed, err := sealSecret(userID, secret)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("error encrypting 2FA secret: %w", err)
 	}
-	return base32.StdEncoding.EncodeToString(secret), nil
-}
-
-func ValidateTOTP(secret string, code string) bool {
-	if len(code) != 6 {
-		return false
-	}
-	
-	inputCode, err := strconv.Atoi(code)
+	_, err = tx.Exec(`
+		UPDATE accounts
+		SET two_factor_secret_sealed = $1, two_factor_key_id = $2, two_factor_enabled = true,
+			two_factor_algorithm = $3, two_factor_digits = $4, two_factor_last_step = $5,
+			two_factor_failures = 0, two_factor_locked_until = NULL
+		WHERE acc_id = $6
+	`, sealed, keyID, params.Algorithm, params.Digits, step, userID)
 	if err != nil {
-		return false
+		return nil, fmt.Errorf("error enabling 2FA: %v", err)
 	}
-
-	// Get current time window
-	now := time.Now().UTC().Unix() / 30
-	
-	// Check current and adjacent time windows
-	for delta := -1; delta <= 1; delta++ {
-		if generateTOTP(secret, now+int64(delta)) == inputCode {
-			return true
-		}
-	}
-	return false
-}
-
-func generateTOTP(secret string, timeWindow int64) int {
-	// Decode base32 secret
-	key, err := base32.StdEncoding.DecodeString(secret)
-	if err != nil {
-		return -1
-	}
-
-	// Create byte array of time
-	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, uint64(timeWindow))
-
-	// Generate HMAC-SHA1
-	h := hmac.New(sha1.New, key)
-	h.Write(buf)
-	sum := h.Sum(nil)
-
-	// Get offset
-	offset := sum[len(sum)-1] & 0xf
-
-	// Generate 4-byte code
-	code := binary.BigEndian.Uint32(sum[offset:offset+4])
-	code &= 0x7fffffff
-	code = code % 1000000
-
-	return int(code)
-}
-
-func Enable2FA(db *sql.DB, userID int, secret string) error {
-	_, err := db.Exec("UPDATE accounts SET two_factor_secret = $1, two_factor_enabled = true WHERE acc_id = $2",
-		secret, userID)
-	return err
+	return generateRecoveryCodes(tx, userID)
 }
 
+// Disable2FA turns off 2FA and discards the user's recovery codes.
 func Disable2FA(db *sql.DB, userID int) error {
-	_, err := db.Exec("UPDATE accounts SET two_factor_secret = NULL, two_factor_enabled = false WHERE acc_id = $1",
-		userID)
+	_, err := db.Exec(`
+		WITH codes AS (DELETE FROM recovery_codes WHERE acc_id = $1)
+		UPDATE accounts
+		SET two_factor_secret_sealed = NULL, two_factor_key_id = NULL, two_factor_enabled = false, two_factor_last_step = NULL,
+			two_factor_failures = 0, two_factor_locked_until = NULL
+		WHERE acc_id = $1
+	`, userID)
 	return err
 }
 
+// GetUser2FAStatus reports whether the user has 2FA enabled, and returns
+// their decrypted secret if so.
 func GetUser2FAStatus(db *sql.DB, userID int) (bool, string, error) {
 	var enabled bool
-	var secret sql.NullString
-	err := db.QueryRow("SELECT two_factor_enabled, two_factor_secret FROM accounts WHERE acc_id = $1", userID).
-		Scan(&enabled, &secret)
+	var keyID sql.NullString
+	var sealed []byte
+	err := db.QueryRow("SELECT two_factor_enabled, two_factor_key_id, two_factor_secret_sealed FROM accounts WHERE acc_id = $1", userID).
+		Scan(&enabled, &keyID, &sealed)
 	if err != nil {
 		return false, "", err
 	}
-	return enabled, secret.String, nil
+	if !enabled || !keyID.Valid {
+		return enabled, "", nil
+	}
+	secret, err := openSecret(userID, keyID.String, sealed)
+	if err != nil {
+		return false, "", fmt.Errorf("error loading 2FA secret: %w", err)
+	}
+	return enabled, secret, nil
 }
 
 func CreateSession(db *sql.DB, userID int) (*Session, error) {
 	sessionID := GenerateSessionID()
-	expiryTime := time.Now().Add(SessionExpiry5Min)
+	expiryTime := time.Now().Add(sessionTTL)
 	
 	session := &Session{
 		SessionID:  sessionID,
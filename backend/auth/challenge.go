@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A login challenge stands for a verified password while the second factor
+// is outstanding. It expires after LoginChallengeTTL, is spent by a correct
+// code, and is spent as well after LoginChallengeAttempts wrong ones.
+const (
+	LoginChallengeTTL      = 5 * time.Minute
+	LoginChallengeAttempts = 5
+)
+
+var (
+	ErrInvalidChallenge   = errors.New("invalid or expired login challenge")
+	ErrChallengeExhausted = errors.New("too many attempts for this login challenge")
+	ErrInvalidCode        = errors.New("invalid 2FA code")
+)
+
+const challengeAudience = "wira-2fa-login"
+
+type challengeClaims struct {
+	UserID int `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// challengeKey signs login challenges. It is derived from the JWT secret so
+// that a challenge can never pass as an access token.
+func challengeKey() []byte {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte("login challenge"))
+	return mac.Sum(nil)
+}
+
+// CreateLoginChallenge records a challenge for a user whose password has
+// just been verified, and returns it signed along with its expiry.
+func CreateLoginChallenge(db *sql.DB, userID int) (string, time.Time, error) {
+	id := GenerateSessionID()
+	now := time.Now()
+	expiresAt := now.Add(LoginChallengeTTL)
+
+	_, err := db.Exec(`
+		INSERT INTO login_challenges (challenge_id, acc_id, expires_at)
+		VALUES ($1, $2, $3)
+	`, id, userID, expiresAt)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error creating login challenge: %v", err)
+	}
+
+	claims := &challengeClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Audience:  jwt.ClaimStrings{challengeAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(challengeKey())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// AnswerLoginChallenge checks a signed challenge and passes its user to
+// verify, which reports whether the code presented is correct. verify runs
+// in the transaction holding the challenge, so anything it writes there is
+// kept only if the challenge is spent. A correct code spends the challenge
+// and returns the user's ID. A wrong one counts
+// against the challenge and returns ErrInvalidCode with the attempts left,
+// or ErrChallengeExhausted on the last attempt. Errors from verify are
+// returned without counting an attempt.
+func AnswerLoginChallenge(db *sql.DB, token string, verify func(tx *sql.Tx, userID int) (bool, error)) (int, int, error) {
+	claims := &challengeClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return challengeKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(challengeAudience))
+	if err != nil || claims.ID == "" {
+		return 0, 0, ErrInvalidChallenge
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the challenge so concurrent answers are counted one at a time
+	var attempts int
+	err = tx.QueryRow(`
+		SELECT attempts
+		FROM login_challenges
+		WHERE challenge_id = $1 AND acc_id = $2 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`, claims.ID, claims.UserID).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrInvalidChallenge
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("error loading login challenge: %v", err)
+	}
+	if attempts >= LoginChallengeAttempts {
+		return 0, 0, ErrChallengeExhausted
+	}
+
+	ok, err := verify(tx, claims.UserID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if ok {
+		_, err = tx.Exec("UPDATE login_challenges SET used_at = NOW() WHERE challenge_id = $1", claims.ID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error spending login challenge: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, 0, err
+		}
+		return claims.UserID, 0, nil
+	}
+
+	attempts++
+	_, err = tx.Exec(`
+		UPDATE login_challenges
+		SET attempts = $2, used_at = CASE WHEN $2 >= $3 THEN NOW() END
+		WHERE challenge_id = $1
+	`, claims.ID, attempts, LoginChallengeAttempts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error recording login attempt: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	if attempts >= LoginChallengeAttempts {
+		return claims.UserID, 0, ErrChallengeExhausted
+	}
+	return claims.UserID, LoginChallengeAttempts - attempts, ErrInvalidCode
+}
+
+// DeleteExpiredLoginChallenges drops challenges that can no longer be
+// answered.
+func DeleteExpiredLoginChallenges(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM login_challenges WHERE expires_at < NOW()")
+	return err
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// An enrollment is confirmed with a code from the new authenticator within
+// EnrollmentTTL, and is discarded after EnrollmentAttempts wrong codes.
+const (
+	EnrollmentTTL      = 10 * time.Minute
+	EnrollmentAttempts = 5
+)
+
+// TOTPIssuer names the service in authenticator apps.
+const TOTPIssuer = "Wira"
+
+var (
+	ErrNoEnrollment      = errors.New("no pending 2FA enrollment")
+	ErrEnrollmentExpired = errors.New("too many invalid codes for this 2FA enrollment")
+)
+
+// Enrollment is a 2FA setup waiting for its first code.
+type Enrollment struct {
+	UserID    int
+	Secret    string
+	Params    TOTPParams
+	ExpiresAt time.Time
+}
+
+// StartEnrollment generates a secret for the user and records it as their
+// pending enrollment, replacing any earlier one.
+func StartEnrollment(db *sql.DB, userID int) (*Enrollment, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	e := &Enrollment{
+		UserID:    userID,
+		Secret:    secret,
+		Params:    EnrollmentParams(),
+		ExpiresAt: time.Now().Add(EnrollmentTTL),
+	}
+	keyID, sealed, err := sealSecret(userID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting 2FA secret: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO two_factor_enrollments (acc_id, secret_sealed, key_id, algorithm, digits, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (acc_id) DO UPDATE
+		SET secret_sealed = $2, key_id = $3, algorithm = $4, digits = $5, attempts = 0,
+			created_at = NOW(), expires_at = $6
+	`, userID, sealed, keyID, e.Params.Algorithm, e.Params.Digits, e.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("error starting 2FA enrollment: %v", err)
+	}
+	return e, nil
+}
+
+// PendingEnrollment returns the user's unexpired enrollment, or
+// ErrNoEnrollment.
+func PendingEnrollment(db *sql.DB, userID int) (*Enrollment, error) {
+	e := &Enrollment{UserID: userID}
+	var keyID string
+	var sealed []byte
+	err := db.QueryRow(`
+		SELECT key_id, secret_sealed, algorithm, digits, expires_at
+		FROM two_factor_enrollments
+		WHERE acc_id = $1 AND expires_at > NOW()
+	`, userID).Scan(&keyID, &sealed, &e.Params.Algorithm, &e.Params.Digits, &e.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoEnrollment
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading 2FA enrollment: %v", err)
+	}
+	if e.Secret, err = openSecret(userID, keyID, sealed); err != nil {
+		return nil, fmt.Errorf("error loading 2FA enrollment: %w", err)
+	}
+	return e, nil
+}
+
+// ConfirmEnrollment checks code against the user's pending enrollment and,
+// if it matches, enables 2FA with the enrollment's secret and returns the
+// new recovery codes. A wrong code returns ErrInvalidCode, or
+// ErrEnrollmentExpired once the enrollment has run out of attempts.
+func ConfirmEnrollment(db *sql.DB, userID int, code string) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var e Enrollment
+	var keyID string
+	var sealed []byte
+	var attempts int
+	err = tx.QueryRow(`
+		SELECT key_id, secret_sealed, algorithm, digits, attempts
+		FROM two_factor_enrollments
+		WHERE acc_id = $1 AND expires_at > NOW()
+		FOR UPDATE
+	`, userID).Scan(&keyID, &sealed, &e.Params.Algorithm, &e.Params.Digits, &attempts)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoEnrollment
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading 2FA enrollment: %v", err)
+	}
+	if e.Secret, err = openSecret(userID, keyID, sealed); err != nil {
+		return nil, fmt.Errorf("error loading 2FA enrollment: %w", err)
+	}
+
+	step, ok := ValidateTOTP(e.Secret, code, e.Params)
+	if !ok {
+		attempts++
+		if attempts >= EnrollmentAttempts {
+			_, err = tx.Exec("DELETE FROM two_factor_enrollments WHERE acc_id = $1", userID)
+		} else {
+			_, err = tx.Exec("UPDATE two_factor_enrollments SET attempts = $1 WHERE acc_id = $2", attempts, userID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error recording enrollment attempt: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		if attempts >= EnrollmentAttempts {
+			return nil, ErrEnrollmentExpired
+		}
+		return nil, ErrInvalidCode
+	}
+
+	codes, err := Enable2FA(tx, userID, e.Secret, e.Params, step)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM two_factor_enrollments WHERE acc_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("error finishing 2FA enrollment: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DeleteExpiredEnrollments drops enrollments that can no longer be
+// confirmed.
+func DeleteExpiredEnrollments(db *sql.DB) error {
+	_, err := db.Exec("DELETE FROM two_factor_enrollments WHERE expires_at < NOW()")
+	return err
+}
+
+// KeyURI returns the otpauth:// URI that authenticator apps import the
+// enrollment from.
+func (e *Enrollment) KeyURI(account string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + TOTPIssuer + ":" + account,
+		RawQuery: url.Values{
+			"secret":    {e.Secret},
+			"issuer":    {TOTPIssuer},
+			"algorithm": {e.Params.Algorithm},
+			"digits":    {strconv.Itoa(e.Params.Digits)},
+			"period":    {strconv.Itoa(int(TOTPPeriod / time.Second))},
+		}.Encode(),
+	}
+	return u.String()
+}
+
+// QRPNG renders the enrollment's key URI as a PNG QR code of size pixels
+// square.
+func (e *Enrollment) QRPNG(account string, size int) ([]byte, error) {
+	return qrcode.Encode(e.KeyURI(account), qrcode.Medium, size)
+}
+
+// QRSVG renders the enrollment's key URI as an SVG QR code, one unit per
+// module, to be scaled by the client.
+func (e *Enrollment) QRSVG(account string) ([]byte, error) {
+	q, err := qrcode.New(e.KeyURI(account), qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	bitmap := q.Bitmap()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %[1]d %[1]d" shape-rendering="crispEdges">`, len(bitmap))
+	fmt.Fprintf(&b, `<rect width="%[1]d" height="%[1]d" fill="#fff"/><path fill="#000" d="`, len(bitmap))
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes(), nil
+}
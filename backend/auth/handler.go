@@ -0,0 +1,697 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+type Handler struct {
+	db      *sql.DB
+	limiter *rateLimiter
+}
+
+// NewHandler builds the account handlers. rdb shares API key rate limits
+// between instances and may be nil.
+func NewHandler(db *sql.DB, rdb redis.UniversalClient) *Handler {
+	return &Handler{
+		db:      db,
+		limiter: newRateLimiter(rdb),
+	}
+}
+
+// RegisterRoutes mounts the account endpoints. Login and session endpoints go
+// on the public group under /auth; profile and 2FA management need an
+// authenticated group.
+func RegisterRoutes(public, protected *gin.RouterGroup, h *Handler) {
+	authRouter := public.Group("/auth")
+	authRouter.POST("/register", h.Register)
+	authRouter.POST("/login", h.Login)
+	authRouter.POST("/2fa/login/verify", h.Login2FA)
+	authRouter.POST("/validate-session", h.ValidateSession)
+	authRouter.POST("/logout", h.Logout)
+
+	protected.GET("/api-keys", h.Require(PermAPIKeysManage), h.ListAPIKeys)
+	protected.POST("/api-keys", h.Require(PermAPIKeysManage), h.CreateAPIKey)
+	protected.DELETE("/api-keys/:keyId", h.Require(PermAPIKeysManage), h.RevokeAPIKey)
+
+	protected.GET("/profile", h.GetProfile)
+	protected.POST("/2fa/enable", h.Enable2FA)
+	protected.GET("/2fa/enable/qr", h.Enrollment2FAQR)
+	protected.POST("/2fa/verify", h.Verify2FA)
+	protected.POST("/2fa/disable", h.Disable2FA)
+	protected.GET("/2fa/recovery-codes", h.RecoveryCodesStatus)
+	protected.POST("/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+}
+
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+}
+
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+func (h *Handler) Register(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if len(req.Username) < 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be at least 3 characters"})
+		return
+	}
+
+	if len(req.Password) < 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 6 characters"})
+		return
+	}
+
+	// Email validation
+	if !emailRegex.MatchString(req.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
+		return
+	}
+
+	// Sanitize inputs
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+	req.Password = strings.TrimSpace(req.Password)
+
+	err := CreateUser(h.db, req.Username, req.Password, req.Email)
+	if err != nil {
+		if strings.Contains(err.Error(), "username already exists") {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
+			return
+		}
+		if strings.Contains(err.Error(), "email already exists") {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
+}
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// LoginResponse carries either a session or, for accounts with 2FA, the
+// challenge to present with the code at /auth/2fa/login/verify.
+type LoginResponse struct {
+	Token              string     `json:"token,omitempty"`
+	User               *User      `json:"user,omitempty"`
+	Requires2FA        bool       `json:"requires_2fa,omitempty"`
+	Challenge          string     `json:"challenge,omitempty"`
+	ChallengeExpiresAt *time.Time `json:"challenge_expires_at,omitempty"`
+	SessionID          string     `json:"sessionID,omitempty"`
+	// RecoveryCodesRemaining is set when a recovery code was used to log in
+	RecoveryCodesRemaining *int `json:"recovery_codes_remaining,omitempty"`
+}
+
+func (h *Handler) Login(c *gin.Context) {
+	var loginReq LoginRequest
+	if err := c.ShouldBindJSON(&loginReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user, err := AuthenticateUser(h.db, loginReq.Username, loginReq.Password)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	// Check if 2FA is enabled
+	twoFactorEnabled, _, err := GetUser2FAStatus(h.db, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check 2FA status"})
+		return
+	}
+
+	if twoFactorEnabled {
+		challenge, expiresAt, err := CreateLoginChallenge(h.db, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start 2FA login"})
+			return
+		}
+		c.JSON(http.StatusOK, LoginResponse{
+			Requires2FA:        true,
+			Challenge:          challenge,
+			ChallengeExpiresAt: &expiresAt,
+		})
+		return
+	}
+
+	h.startSession(c, user, LoginResponse{})
+}
+
+// startSession issues a token and session for an authenticated user and
+// writes them into resp as the login response.
+func (h *Handler) startSession(c *gin.Context, user *User, resp LoginResponse) {
+	// Generate JWT token
+	token, err := GenerateToken(*user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Create session
+	session, err := CreateSession(h.db, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
+	resp.Token = token
+	resp.User = user
+	resp.SessionID = session.SessionID
+	c.JSON(http.StatusOK, resp)
+}
+
+// Login2FA completes a login with the challenge issued for the password and
+// either a TOTP code or a recovery code. A challenge that has expired, been used or run out of
+// attempts is answered with login_required, and the client must start over
+// with the password.
+func (h *Handler) Login2FA(c *gin.Context) {
+	var req struct {
+		Challenge    string `json:"challenge" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "") == (req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	method := "totp"
+	if req.RecoveryCode != "" {
+		method = "recovery_code"
+	}
+
+	var user User
+	var codesLeft int
+	userID, remaining, err := AnswerLoginChallenge(h.db, req.Challenge, func(tx *sql.Tx, userID int) (bool, error) {
+		err := tx.QueryRow(`
+			SELECT acc_id, username, email
+			FROM accounts
+			WHERE acc_id = $1 AND two_factor_enabled = true`,
+			userID,
+		).Scan(&user.ID, &user.Username, &user.Email)
+		if err == sql.ErrNoRows {
+			// 2FA was turned off since the challenge was issued
+			return false, ErrInvalidChallenge
+		}
+		if err != nil {
+			return false, err
+		}
+		if req.RecoveryCode != "" {
+			// Recovery codes are too long to guess, so they work even while
+			// wrong TOTP codes have the account locked
+			ok, left, err := UseRecoveryCode(tx, userID, req.RecoveryCode)
+			codesLeft = left
+			return ok, err
+		}
+		return VerifyTOTP(h.db, userID, req.Code)
+	})
+
+	var locked *TOTPLockedError
+	if errors.As(err, &locked) {
+		h.Audit(c, AuditEvent{
+			AccID:    userID,
+			Action:   "login_2fa",
+			Outcome:  AuditDenied,
+			Reason:   "account locked",
+			Metadata: map[string]interface{}{"locked_until": locked.Until},
+		})
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid 2FA codes; try again later", "locked_until": locked.Until})
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrInvalidCode:
+		h.Audit(c, AuditEvent{
+			AccID:    userID,
+			Action:   "login_2fa",
+			Outcome:  AuditDenied,
+			Reason:   err.Error(),
+			Metadata: map[string]interface{}{"method": method, "attempts_remaining": remaining},
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid 2FA code", "attempts_remaining": remaining})
+		return
+	case ErrChallengeExhausted:
+		h.Audit(c, AuditEvent{
+			AccID:   userID,
+			Action:  "login_2fa",
+			Outcome: AuditDenied,
+			Reason:  err.Error(),
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many invalid codes; please log in again", "login_required": true})
+		return
+	case ErrTOTPReused:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "This 2FA code has already been used; wait for the next one"})
+		return
+	case ErrInvalidChallenge:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired; please log in again", "login_required": true})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify 2FA code"})
+		return
+	}
+
+	var resp LoginResponse
+	if req.RecoveryCode != "" {
+		h.Audit(c, AuditEvent{
+			AccID:    userID,
+			Action:   "recovery_code_used",
+			Outcome:  AuditAllowed,
+			Metadata: map[string]interface{}{"recovery_codes_remaining": codesLeft},
+		})
+		resp.RecoveryCodesRemaining = &codesLeft
+	}
+	h.startSession(c, &user, resp)
+}
+
+func (h *Handler) ValidateSession(c *gin.Context) {
+	var req struct {
+		SessionID string `json:"sessionID" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	session, err := ValidateSession(h.db, req.SessionID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":   true,
+		"session": session,
+	})
+}
+
+func (h *Handler) Logout(c *gin.Context) {
+	sessionID := c.GetHeader("X-Session-ID")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID is required"})
+		return
+	}
+
+	err := DeleteSession(h.db, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
+}
+
+func (h *Handler) GetProfile(c *gin.Context) {
+	var profile struct {
+		ID               int       `json:"id"`
+		Username         string    `json:"username"`
+		Email            string    `json:"email"`
+		CreatedAt        time.Time `json:"created_at"`
+		TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	}
+
+	err := h.db.QueryRow(`
+		SELECT acc_id, username, email, created_at, two_factor_enabled
+		FROM accounts
+		WHERE acc_id = $1
+	`, c.GetInt("userID")).Scan(&profile.ID, &profile.Username, &profile.Email, &profile.CreatedAt, &profile.TwoFactorEnabled)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+type Enable2FARequest struct {
+	Password string `json:"password"`
+}
+
+type Verify2FARequest struct {
+	Code string `json:"code"`
+}
+
+// Enable2FA starts an enrollment once the user has entered their password.
+// The secret is kept server-side until a code confirms it at /2fa/verify;
+// it is returned here only for entering into an authenticator by hand.
+func (h *Handler) Enable2FA(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userClaims := claims.(*Claims)
+	var req Enable2FARequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	// Verify password
+	var passwordHash string
+	var enabled bool
+	err := h.db.QueryRow("SELECT password_hash, two_factor_enabled FROM accounts WHERE acc_id = $1", userClaims.UserID).
+		Scan(&passwordHash, &enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+
+	if !CheckPasswordHash(req.Password, passwordHash) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		return
+	}
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "2FA is already enabled"})
+		return
+	}
+
+	enrollment, err := StartEnrollment(h.db, userClaims.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      enrollment.Secret,
+		"otpauth_url": enrollment.KeyURI(userClaims.Username),
+		"algorithm":   enrollment.Params.Algorithm,
+		"digits":      enrollment.Params.Digits,
+		"expires_at":  enrollment.ExpiresAt,
+	})
+}
+
+// Enrollment2FAQR renders the pending enrollment as a QR code, as a PNG by
+// default or as SVG with ?format=svg. ?size sets the PNG width in pixels.
+func (h *Handler) Enrollment2FAQR(c *gin.Context) {
+	enrollment, err := PendingEnrollment(h.db, c.GetInt("userID"))
+	if err != nil {
+		if err == ErrNoEnrollment {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no pending 2FA enrollment"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+
+	// The image holds the secret
+	c.Header("Cache-Control", "no-store")
+
+	account := c.GetString("username")
+	switch c.DefaultQuery("format", "png") {
+	case "png":
+		size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+		if err != nil || size < 64 || size > 1024 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "size must be between 64 and 1024"})
+			return
+		}
+		img, err := enrollment.QRPNG(account, size)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render QR code"})
+			return
+		}
+		c.Data(http.StatusOK, "image/png", img)
+	case "svg":
+		img, err := enrollment.QRSVG(account)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render QR code"})
+			return
+		}
+		c.Data(http.StatusOK, "image/svg+xml", img)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be png or svg"})
+	}
+}
+
+// Verify2FA confirms the pending enrollment with a code from the new
+// authenticator and enables 2FA.
+func (h *Handler) Verify2FA(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userClaims := claims.(*Claims)
+	var req Verify2FARequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	codes, err := ConfirmEnrollment(h.db, userClaims.UserID, req.Code)
+	if err != nil {
+		switch err {
+		case ErrInvalidCode:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		case ErrNoEnrollment, ErrEnrollmentExpired:
+			c.JSON(http.StatusGone, gin.H{"error": "2FA setup has expired; please start again"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable 2FA"})
+		}
+		return
+	}
+
+	h.Audit(c, AuditEvent{
+		Action:  "2fa_enabled",
+		Outcome: AuditAllowed,
+	})
+
+	// The recovery codes are only ever shown here and on regeneration
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "2FA enabled successfully",
+		"recovery_codes": codes,
+	})
+}
+
+type RegenerateRecoveryCodesRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes once they have
+// entered their password again.
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req RegenerateRecoveryCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	var passwordHash string
+	var enabled bool
+	err := h.db.QueryRow("SELECT password_hash, two_factor_enabled FROM accounts WHERE acc_id = $1", userID).
+		Scan(&passwordHash, &enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+
+	if !CheckPasswordHash(req.Password, passwordHash) {
+		h.Audit(c, AuditEvent{
+			Action:  "recovery_codes_regenerated",
+			Outcome: AuditDenied,
+			Reason:  "invalid password",
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		return
+	}
+	if !enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "2FA is not enabled"})
+		return
+	}
+
+	codes, err := RegenerateRecoveryCodes(h.db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		return
+	}
+
+	h.Audit(c, AuditEvent{
+		Action:   "recovery_codes_regenerated",
+		Outcome:  AuditAllowed,
+		Metadata: map[string]interface{}{"recovery_codes_remaining": len(codes)},
+	})
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// RecoveryCodesStatus reports how many unused recovery codes the user has.
+func (h *Handler) RecoveryCodesStatus(c *gin.Context) {
+	n, err := CountRecoveryCodes(h.db, c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes_remaining": n})
+}
+
+// Disable2FARequest proves the user is present with either a current TOTP
+// code or their password.
+type Disable2FARequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+func (h *Handler) Disable2FA(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userClaims := claims.(*Claims)
+	var req Disable2FARequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "") == (req.Password == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a 2FA code or the password is required"})
+		return
+	}
+
+	var passwordHash string
+	var enabled bool
+	err := h.db.QueryRow("SELECT password_hash, two_factor_enabled FROM accounts WHERE acc_id = $1", userClaims.UserID).
+		Scan(&passwordHash, &enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+		return
+	}
+	if !enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "2FA not enabled for this user"})
+		return
+	}
+
+	method, ok := "password", false
+	if req.Code != "" {
+		method = "code"
+		ok, err = VerifyTOTP(h.db, userClaims.UserID, req.Code)
+		var locked *TOTPLockedError
+		switch {
+		case errors.As(err, &locked):
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(locked.Until).Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many invalid 2FA codes; try again later", "locked_until": locked.Until})
+			return
+		case err == ErrTOTPReused:
+			ok = false
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+			return
+		}
+	} else {
+		ok = CheckPasswordHash(req.Password, passwordHash)
+	}
+	if !ok {
+		h.Audit(c, AuditEvent{
+			Action:   "2fa_disabled",
+			Outcome:  AuditDenied,
+			Reason:   "invalid " + method,
+			Metadata: map[string]interface{}{"method": method},
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid " + method})
+		return
+	}
+
+	if err := Disable2FA(h.db, userClaims.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable 2FA"})
+		return
+	}
+
+	h.Audit(c, AuditEvent{
+		Action:   "2fa_disabled",
+		Outcome:  AuditAllowed,
+		Metadata: map[string]interface{}{"method": method},
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "2FA disabled successfully"})
+}
+
+type CreateAPIKeyRequest struct {
+	AccountID          int          `json:"account_id" binding:"required"`
+	Name               string       `json:"name" binding:"required"`
+	Scopes             []Permission `json:"scopes" binding:"required"`
+	RateLimitPerMinute int          `json:"rate_limit_per_minute"`
+}
+
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RateLimitPerMinute <= 0 {
+		req.RateLimitPerMinute = 600
+	}
+
+	key, secret, err := CreateAPIKey(h.db, req.AccountID, req.Name, req.Scopes, req.RateLimitPerMinute)
+	if err != nil {
+		switch err {
+		case ErrInvalidScope, ErrNotServiceAccount:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		}
+		return
+	}
+
+	h.Audit(c, AuditEvent{
+		Action:   "api_key_created",
+		Outcome:  AuditAllowed,
+		Metadata: map[string]interface{}{"key_id": key.KeyID, "account_id": key.AccID, "scopes": key.Scopes},
+	})
+
+	// The secret is only ever shown here
+	c.JSON(http.StatusCreated, gin.H{
+		"key":    key,
+		"secret": secret,
+	})
+}
+
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := ListAPIKeys(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	keyID := c.Param("keyId")
+	if err := RevokeAPIKey(h.db, keyID); err != nil {
+		if err == ErrAPIKeyNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	h.Audit(c, AuditEvent{
+		Action:   "api_key_revoked",
+		Outcome:  AuditAllowed,
+		Metadata: map[string]interface{}{"key_id": keyID},
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
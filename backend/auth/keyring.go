@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TOTP secrets and API signing keys are stored with envelope encryption:
+// each secret is sealed with AES-256-GCM under its own random data key, and
+// the data key is sealed under a key from the key ring. The ring key's ID is
+// stored next to the ciphertext, so keys can be rotated by re-sealing data
+// keys alone. A sealed secret is
+//
+//	version | wrap nonce | sealed data key | data nonce | sealed secret
+//
+// The secret is bound to the row it belongs to and the data key to its ring
+// key, so ciphertexts cannot be moved between rows or relabelled.
+const sealVersion = 1
+
+var (
+	ErrNoKeyRing  = errors.New("no TOTP encryption keys configured")
+	ErrUnknownKey = errors.New("secret sealed with a key that is not in the key ring")
+	ErrBadSealed  = errors.New("sealed secret cannot be decrypted")
+)
+
+// KeyRing holds the keys secrets are sealed with, by ID. New secrets
+// use the active key; the others open secrets sealed before a rotation.
+type KeyRing struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// ParseKeyRing builds a key ring from "id:base64" entries, each a 32-byte
+// AES-256 key. active names the key for new secrets; if empty, the first
+// entry is used.
+func ParseKeyRing(entries []string, active string) (*KeyRing, error) {
+	if len(entries) == 0 {
+		return nil, ErrNoKeyRing
+	}
+	r := &KeyRing{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range entries {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("TOTP encryption key %q: must look like id:base64key", id)
+		}
+		if _, dup := r.keys[id]; dup {
+			return nil, fmt.Errorf("TOTP encryption key %q is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("TOTP encryption key %q: must be 32 bytes, base64 encoded", id)
+		}
+		r.keys[id], err = newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if r.active == "" {
+			r.active = id
+		}
+	}
+	if active != "" {
+		if _, ok := r.keys[active]; !ok {
+			return nil, fmt.Errorf("active TOTP encryption key %q is not in the key ring", active)
+		}
+		r.active = active
+	}
+	return r, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ActiveID returns the ID of the key new secrets are sealed with.
+func (r *KeyRing) ActiveID() string {
+	return r.active
+}
+
+// secretAAD binds a sealed TOTP secret to the account it belongs to.
+func secretAAD(userID int) []byte {
+	return []byte("wira totp secret:" + strconv.Itoa(userID))
+}
+
+// signingKeyAAD binds a sealed signing key to its API key.
+func signingKeyAAD(keyID string) []byte {
+	return []byte("wira api signing key:" + keyID)
+}
+
+// Seal encrypts a user's secret and returns the ID of the key it was sealed
+// with along with the sealed bytes.
+func (r *KeyRing) Seal(userID int, secret string) (string, []byte, error) {
+	return r.seal([]byte(secret), secretAAD(userID))
+}
+
+// Open decrypts a secret sealed for the user with the key keyID.
+func (r *KeyRing) Open(userID int, keyID string, sealed []byte) (string, error) {
+	secret, err := r.open(keyID, sealed, secretAAD(userID))
+	return string(secret), err
+}
+
+// seal encrypts plaintext bound to aad under a fresh data key, wrapped with
+// the active key.
+func (r *KeyRing) seal(plaintext, aad []byte) (string, []byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", nil, err
+	}
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, data.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	body := data.Seal(nonce, nonce, plaintext, aad)
+
+	wrapped, err := r.wrap(r.active, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	out := append([]byte{sealVersion}, wrapped...)
+	return r.active, append(out, body...), nil
+}
+
+// open decrypts bytes sealed with keyID and bound to aad.
+func (r *KeyRing) open(keyID string, sealed, aad []byte) ([]byte, error) {
+	dataKey, body, err := r.unwrap(keyID, sealed)
+	if err != nil {
+		return nil, err
+	}
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < data.NonceSize() {
+		return nil, ErrBadSealed
+	}
+	plaintext, err := data.Open(nil, body[:data.NonceSize()], body[data.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrBadSealed
+	}
+	return plaintext, nil
+}
+
+// Rewrap re-seals the data key of a secret sealed with keyID under the
+// active key. The secret itself is not decrypted.
+func (r *KeyRing) Rewrap(keyID string, sealed []byte) (string, []byte, error) {
+	dataKey, body, err := r.unwrap(keyID, sealed)
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err := r.wrap(r.active, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	out := append([]byte{sealVersion}, wrapped...)
+	return r.active, append(out, body...), nil
+}
+
+// wrap seals a data key under the ring key keyID, as nonce | ciphertext.
+func (r *KeyRing) wrap(keyID string, dataKey []byte) ([]byte, error) {
+	kek := r.keys[keyID]
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return kek.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// unwrap opens the data key of a sealed secret and returns it with the rest
+// of the sealed bytes.
+func (r *KeyRing) unwrap(keyID string, sealed []byte) ([]byte, []byte, error) {
+	kek, ok := r.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	wrappedLen := kek.NonceSize() + 32 + kek.Overhead()
+	if len(sealed) < 1+wrappedLen || sealed[0] != sealVersion {
+		return nil, nil, ErrBadSealed
+	}
+	wrapped := sealed[1 : 1+wrappedLen]
+	dataKey, err := kek.Open(nil, wrapped[:kek.NonceSize()], wrapped[kek.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, nil, ErrBadSealed
+	}
+	return dataKey, sealed[1+wrappedLen:], nil
+}
+
+var keyRing *KeyRing
+
+// SetKeyRing sets the keys TOTP secrets and API signing keys are sealed
+// with.
+func SetKeyRing(r *KeyRing) {
+	keyRing = r
+}
+
+// sealSecret seals a user's secret with the configured key ring.
+func sealSecret(userID int, secret string) (string, []byte, error) {
+	if keyRing == nil {
+		return "", nil, ErrNoKeyRing
+	}
+	return keyRing.Seal(userID, secret)
+}
+
+// openSecret opens a user's secret with the configured key ring.
+func openSecret(userID int, keyID string, sealed []byte) (string, error) {
+	if keyRing == nil {
+		return "", ErrNoKeyRing
+	}
+	return keyRing.Open(userID, keyID, sealed)
+}
+
+// sealSigningKey seals an API key's signing key with the configured key ring.
+func sealSigningKey(keyID string, signingKey []byte) (string, []byte, error) {
+	if keyRing == nil {
+		return "", nil, ErrNoKeyRing
+	}
+	return keyRing.seal(signingKey, signingKeyAAD(keyID))
+}
+
+// openSigningKey opens an API key's signing key with the configured key ring.
+func openSigningKey(keyID, ringKeyID string, sealed []byte) ([]byte, error) {
+	if keyRing == nil {
+		return nil, ErrNoKeyRing
+	}
+	return keyRing.open(ringKeyID, sealed, signingKeyAAD(keyID))
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSignedBody bounds how much of a signed request is read to verify it
+const maxSignedBody = 1 << 20
+
+// RequireAuth accepts a user's bearer token and stores the caller on the
+// context for the handlers behind it. Requests signed with an API key are
+// rejected.
+func (h *Handler) RequireAuth() gin.HandlerFunc {
+	return h.requireAuth(false)
+}
+
+// RequireAuthOrAPIKey also accepts a request signed with a game server API
+// key. Every route behind it must check its permission with Require, which
+// holds keys to their scopes.
+func (h *Handler) RequireAuthOrAPIKey() gin.HandlerFunc {
+	return h.requireAuth(true)
+}
+
+func (h *Handler) requireAuth(allowAPIKeys bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if keyID := c.GetHeader(HeaderAPIKey); keyID != "" {
+			if !allowAPIKeys {
+				h.Audit(c, AuditEvent{
+					Action:   "api_key_rejected",
+					Outcome:  AuditDenied,
+					Reason:   "route does not accept api keys",
+					Metadata: map[string]interface{}{"key_id": keyID},
+				})
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "API keys are not accepted on this route"})
+				c.Abort()
+				return
+			}
+			h.authenticateAPIKey(c)
+			return
+		}
+
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+			c.Abort()
+			return
+		}
+
+		bearerToken := strings.Split(authHeader, " ")
+		if len(bearerToken) != 2 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+			c.Abort()
+			return
+		}
+
+		claims, err := ValidateToken(bearerToken[1])
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", claims.UserID)
+		c.Set("username", claims.Username)
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+// authenticateAPIKey verifies a signed game server request. The body is
+// read for the signature and put back for the handler.
+func (h *Handler) authenticateAPIKey(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBody+1))
+	if err != nil || len(body) > maxSignedBody {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	req := SignedRequest{
+		KeyID:     c.GetHeader(HeaderAPIKey),
+		Timestamp: c.GetHeader(HeaderTimestamp),
+		Nonce:     c.GetHeader(HeaderNonce),
+		Signature: c.GetHeader(HeaderSignature),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.RequestURI(),
+		Body:      body,
+	}
+	key, err := VerifySignedRequest(h.db, req)
+	if err != nil {
+		switch err {
+		case ErrAPIKeyNotFound, ErrInvalidSignature, ErrStaleRequest, ErrReplayedRequest:
+			h.Audit(c, AuditEvent{
+				Action:   "api_key_rejected",
+				Outcome:  AuditDenied,
+				Reason:   err.Error(),
+				Metadata: map[string]interface{}{"key_id": req.KeyID},
+			})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API key"})
+		}
+		c.Abort()
+		return
+	}
+
+	if !h.limiter.Allow(c.Request.Context(), key.KeyID, key.RateLimitPerMinute) {
+		h.Audit(c, AuditEvent{
+			AccID:    key.AccID,
+			Action:   "api_key_rate_limited",
+			Outcome:  AuditDenied,
+			Metadata: map[string]interface{}{"key_id": key.KeyID},
+		})
+		c.Header("Retry-After", "60")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+		c.Abort()
+		return
+	}
+
+	c.Set("userID", key.AccID)
+	c.Set("apiKey", key)
+	c.Next()
+}
+
+// Require must run after RequireAuth. It lets the request through when the
+// caller holds any of perms, and audits every rejection.
+func (h *Handler) Require(perms ...Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// API keys carry their permissions as scopes
+		if _, ok := c.Get("apiKey"); !ok {
+			role, err := GetUserRole(h.db, c.GetInt("userID"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+				c.Abort()
+				return
+			}
+			c.Set("role", role)
+		}
+
+		for _, perm := range perms {
+			if HasPermission(c, perm) {
+				c.Next()
+				return
+			}
+		}
+
+		metadata := map[string]interface{}{"required": perms}
+		if key, ok := c.Get("apiKey"); ok {
+			metadata["key_id"] = key.(*APIKey).KeyID
+		} else {
+			metadata["role"] = c.GetString("role")
+		}
+		h.Audit(c, AuditEvent{
+			Action:   "permission_denied",
+			Outcome:  AuditDenied,
+			Reason:   "caller lacks required permission",
+			Metadata: metadata,
+		})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
+	}
+}
+
+// Audit records an event for the request's caller and route. Failures are
+// logged rather than returned so auditing never changes the response.
+func (h *Handler) Audit(c *gin.Context, event AuditEvent) {
+	if event.AccID == 0 {
+		event.AccID = c.GetInt("userID")
+	}
+	if event.Resource == "" {
+		event.Resource = c.Request.Method + " " + c.Request.URL.Path
+	}
+	if event.ClientIP == "" {
+		event.ClientIP = c.ClientIP()
+	}
+	audit(h.db, event)
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireAuthRejectsAPIKeys(t *testing.T) {
+	db := openTestDB(t)
+	h := NewHandler(db, nil)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/profile", h.RequireAuth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// The key is refused before its signature is even checked
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.Header.Set(HeaderAPIKey, "any-key")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("API key on a user route = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	var audited int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM audit_log
+		WHERE action = 'api_key_rejected' AND resource = 'GET /profile' AND metadata->>'key_id' = 'any-key'
+	`).Scan(&audited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audited != 1 {
+		t.Errorf("%d rejections audited, want 1", audited)
+	}
+}
@@ -0,0 +1,45 @@
+package auth
+
+import "github.com/gin-gonic/gin"
+
+// Permission names an action a principal may take. API key scopes use the
+// same names.
+type Permission string
+
+const (
+	PermScoresRead        Permission = "scores:read"
+	PermScoresWrite       Permission = "scores:write"
+	PermScoresCorrect     Permission = "scores:correct"
+	PermSeasonsManage     Permission = "seasons:manage"
+	PermLeaderboardManage Permission = "leaderboard:manage"
+	PermAPIKeysManage     Permission = "apikeys:manage"
+)
+
+// rolePermissions maps account roles to what they may do with a user token.
+// Players only read and admins may correct scores; submitting scores needs an
+// API key with the scores:write scope, issued to a game server account.
+var rolePermissions = map[string][]Permission{
+	RolePlayer:     {PermScoresRead},
+	RoleGameServer: {PermScoresRead},
+	RoleAdmin:      {PermScoresRead, PermScoresCorrect, PermSeasonsManage, PermLeaderboardManage, PermAPIKeysManage},
+}
+
+// RoleAllows reports whether a role grants a permission.
+func RoleAllows(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the authenticated caller of a request holds a
+// permission: through its scopes for an API key, otherwise through the role
+// loaded by Require.
+func HasPermission(c *gin.Context, perm Permission) bool {
+	if key, ok := c.Get("apiKey"); ok {
+		return key.(*APIKey).HasScope(perm)
+	}
+	return RoleAllows(c.GetString("role"), perm)
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// rateLimiter counts requests per API key in fixed one-minute windows. With
+// Redis the count is shared by every instance; without it each instance
+// counts on its own.
+type rateLimiter struct {
+	rdb redis.UniversalClient
+
+	mu     sync.Mutex
+	window int64
+	counts map[string]int
+}
+
+func newRateLimiter(rdb redis.UniversalClient) *rateLimiter {
+	return &rateLimiter{rdb: rdb, counts: make(map[string]int)}
+}
+
+// Allow records a request for key and reports whether it is within limit.
+func (l *rateLimiter) Allow(ctx context.Context, key string, limit int) bool {
+	window := time.Now().Unix() / 60
+
+	if l.rdb != nil {
+		redisKey := fmt.Sprintf("ratelimit:apikey:%s:%d", key, window)
+		pipe := l.rdb.TxPipeline()
+		incr := pipe.Incr(ctx, redisKey)
+		pipe.Expire(ctx, redisKey, 2*time.Minute)
+		_, err := pipe.Exec(ctx)
+		if err == nil {
+			return incr.Val() <= int64(limit)
+		}
+		log.Printf("Warning: rate limiter falling back to local counts: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if window != l.window {
+		l.window = window
+		l.counts = make(map[string]int)
+	}
+	l.counts[key]++
+	return l.counts[key] <= limit
+}
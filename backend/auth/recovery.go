@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes a user holds at a time. Each
+// logs in once in place of a TOTP code.
+const RecoveryCodeCount = 10
+
+// generateRecoveryCodes replaces the user's recovery codes with a new set
+// and returns them; only their hashes are stored.
+func generateRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE acc_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("error deleting recovery codes: %v", err)
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		// 80 random bits, shown as XXXX-XXXX-XXXX-XXXX
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := base32.StdEncoding.EncodeToString(b)
+		codes[i] = s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+
+		_, err := tx.Exec("INSERT INTO recovery_codes (acc_id, code_hash) VALUES ($1, $2)",
+			userID, hashRecoveryCode(codes[i]))
+		if err != nil {
+			return nil, fmt.Errorf("error storing recovery code: %v", err)
+		}
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a code as typed, ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, invalidating
+// any that were left.
+func RegenerateRecoveryCodes(db *sql.DB, userID int) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	codes, err := generateRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes in tx, so the code
+// stays unused if the login it is part of does not commit. It reports
+// whether the code was valid and unused, and how many codes remain.
+func UseRecoveryCode(tx *sql.Tx, userID int, code string) (bool, int, error) {
+	result, err := tx.Exec(`
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE acc_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, 0, fmt.Errorf("error using recovery code: %v", err)
+	}
+	used, _ := result.RowsAffected()
+
+	remaining, err := CountRecoveryCodes(tx, userID)
+	if err != nil {
+		return false, 0, err
+	}
+	return used == 1, remaining, nil
+}
+
+// querier is a *sql.DB or a *sql.Tx.
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has.
+func CountRecoveryCodes(q querier, userID int) (int, error) {
+	var n int
+	err := q.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE acc_id = $1 AND used_at IS NULL", userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("error counting recovery codes: %v", err)
+	}
+	return n, nil
+}
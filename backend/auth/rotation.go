@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// SealPlaintextSecrets seals the secrets stored before secrets were
+// encrypted. It is the Go step of migration 019 and runs in its transaction.
+func SealPlaintextSecrets(ctx context.Context, tx *sql.Tx) error {
+	type plaintext struct {
+		userID int
+		secret string
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT acc_id, two_factor_secret FROM accounts WHERE two_factor_secret IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	var secrets []plaintext
+	for rows.Next() {
+		var p plaintext
+		if err := rows.Scan(&p.userID, &p.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		secrets = append(secrets, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range secrets {
+		keyID, sealed, err := sealSecret(p.userID, p.secret)
+		if err != nil {
+			return fmt.Errorf("error encrypting 2FA secret of account %d: %w", p.userID, err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE accounts
+			SET two_factor_secret_sealed = $1, two_factor_key_id = $2, two_factor_secret = NULL
+			WHERE acc_id = $3
+		`, sealed, keyID, p.userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SealAPISigningKeys moves the signing keys stored in api_keys.secret_hash
+// into signing_key_sealed and leaves a verifier in their place. It is the Go
+// step of migration 021 and runs in its transaction.
+func SealAPISigningKeys(ctx context.Context, tx *sql.Tx) error {
+	type plaintext struct {
+		keyID      string
+		signingKey []byte
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT key_id, secret_hash FROM api_keys WHERE signing_key_sealed IS NULL")
+	if err != nil {
+		return err
+	}
+	var keys []plaintext
+	for rows.Next() {
+		var p plaintext
+		if err := rows.Scan(&p.keyID, &p.signingKey); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range keys {
+		ringKeyID, sealed, err := sealSigningKey(p.keyID, p.signingKey)
+		if err != nil {
+			return fmt.Errorf("error encrypting api key %s: %w", p.keyID, err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE api_keys SET signing_key_sealed = $1, signing_key_id = $2, secret_hash = $3
+			WHERE key_id = $4
+		`, sealed, ringKeyID, secretVerifier(p.signingKey), p.keyID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Every row is sealed now, so new keys must be too
+	_, err = tx.ExecContext(ctx, `
+		ALTER TABLE api_keys
+			ALTER COLUMN signing_key_sealed SET NOT NULL,
+			ALTER COLUMN signing_key_id SET NOT NULL
+	`)
+	return err
+}
+
+// sealedTables are the tables holding sealed secrets, with their key and
+// sealed columns.
+var sealedTables = []struct {
+	table, id, keyID, sealed string
+}{
+	{"accounts", "acc_id", "two_factor_key_id", "two_factor_secret_sealed"},
+	{"two_factor_enrollments", "acc_id", "key_id", "secret_sealed"},
+	{"api_keys", "key_id", "signing_key_id", "signing_key_sealed"},
+}
+
+// ResealSecrets moves every TOTP secret and API signing key sealed with
+// another key onto the ring's active key, batch rows per transaction, and
+// returns how many it moved. Only the data keys are re-sealed. Rows locked by
+// another transaction at the time are skipped rather than waited for, so it
+// runs against a live database and may leave a few behind; once status shows
+// no secrets under the old keys, they can be removed from the ring.
+func ResealSecrets(ctx context.Context, db *sql.DB, ring *KeyRing, batch int) (int, error) {
+	total := 0
+	for _, t := range sealedTables {
+		for {
+			n, err := resealBatch(ctx, db, ring, t.table, t.id, t.keyID, t.sealed, batch)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			if n == 0 {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+func resealBatch(ctx context.Context, db *sql.DB, ring *KeyRing, table, id, keyCol, sealedCol string, batch int) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	type row struct {
+		id     string
+		keyID  string
+		sealed []byte
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %[1]s, %[2]s, %[3]s FROM %[4]s
+		WHERE %[2]s <> $1
+		ORDER BY %[1]s
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, id, keyCol, sealedCol, table), ring.ActiveID(), batch)
+	if err != nil {
+		return 0, err
+	}
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.keyID, &r.sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range stale {
+		keyID, sealed, err := ring.Rewrap(r.keyID, r.sealed)
+		if err != nil {
+			return 0, fmt.Errorf("%s %s: %w", table, r.id, err)
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s = $1, %s = $2 WHERE %s = $3", table, keyCol, sealedCol, id),
+			keyID, sealed, r.id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(stale), nil
+}
+
+// KeysUsage describes the commands accepted by KeysCommand.
+const KeysUsage = `commands:
+  status             count secrets sealed with each key
+  reseal [-batch n]  move secrets sealed with other keys onto the active key`
+
+// KeysCommand runs one command on the stored TOTP secrets and API signing
+// keys with the configured key ring, writing its report to w.
+func KeysCommand(ctx context.Context, db *sql.DB, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", KeysUsage)
+	}
+	if keyRing == nil {
+		return ErrNoKeyRing
+	}
+
+	switch args[0] {
+	case "status":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TABLE\tKEY\tSECRETS")
+		for _, t := range sealedTables {
+			rows, err := db.QueryContext(ctx, fmt.Sprintf(
+				"SELECT %[1]s, COUNT(*) FROM %[2]s WHERE %[1]s IS NOT NULL GROUP BY %[1]s ORDER BY %[1]s", t.keyID, t.table))
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var keyID string
+				var n int
+				if err := rows.Scan(&keyID, &n); err != nil {
+					rows.Close()
+					return err
+				}
+				if keyID == keyRing.ActiveID() {
+					keyID += " (active)"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%d\n", t.table, keyID, n)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+		return tw.Flush()
+
+	case "reseal":
+		flags := flag.NewFlagSet("reseal", flag.ContinueOnError)
+		flags.SetOutput(w)
+		batch := flags.Int("batch", 500, "rows re-sealed per transaction")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *batch < 1 {
+			return errors.New("-batch must be at least 1")
+		}
+		n, err := ResealSecrets(ctx, db, keyRing, *batch)
+		fmt.Fprintf(w, "%d secrets re-sealed with key %s\n", n, keyRing.ActiveID())
+		return err
+
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], KeysUsage)
+	}
+}
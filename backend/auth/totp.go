@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TOTP hash algorithms
+const (
+	TOTPSHA1   = "SHA1"
+	TOTPSHA256 = "SHA256"
+	TOTPSHA512 = "SHA512"
+)
+
+// TOTPPeriod is the length of a TOTP time step.
+const TOTPPeriod = 30 * time.Second
+
+// TOTPOptions control TOTP codes. Digits and Algorithm apply to new
+// enrollments; an account keeps the parameters it was enrolled with. Skew is
+// how many time steps either side of the current one are accepted. After
+// MaxFailures wrong codes in a row an account is locked for Lockout, which
+// doubles with each further failure up to MaxLockout.
+type TOTPOptions struct {
+	Digits      int
+	Algorithm   string
+	Skew        int
+	MaxFailures int
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+}
+
+var totpOptions = TOTPOptions{
+	Digits:      6,
+	Algorithm:   TOTPSHA1,
+	Skew:        1,
+	MaxFailures: 5,
+	Lockout:     30 * time.Second,
+	MaxLockout:  15 * time.Minute,
+}
+
+// SetTOTPOptions replaces the TOTP options.
+func SetTOTPOptions(opts TOTPOptions) {
+	totpOptions = opts
+}
+
+// TOTPParams are the parameters an authenticator generates codes with.
+type TOTPParams struct {
+	Algorithm string
+	Digits    int
+}
+
+// EnrollmentParams returns the parameters for new enrollments.
+func EnrollmentParams() TOTPParams {
+	return TOTPParams{Algorithm: totpOptions.Algorithm, Digits: totpOptions.Digits}
+}
+
+// ErrTOTPReused is returned for a code from a time step that has already
+// been used to log in.
+var ErrTOTPReused = errors.New("2FA code already used")
+
+// TOTPLockedError is returned while an account is locked after too many
+// wrong codes.
+type TOTPLockedError struct {
+	Until time.Time
+}
+
+func (e *TOTPLockedError) Error() string {
+	return fmt.Sprintf("too many invalid 2FA codes; try again after %s", e.Until.Format(time.RFC3339))
+}
+
+func GenerateSecret() (string, error) {
+	// Generate a random 20-byte secret
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(secret), nil
+}
+
+// ValidateTOTP checks code against secret without any account state, for
+// confirming an enrollment. It returns the time step the code matched.
+func ValidateTOTP(secret, code string, params TOTPParams) (int64, bool) {
+	return matchTOTP(secret, code, params, time.Now())
+}
+
+// VerifyTOTP checks a login code against the user's enrolled secret. Each
+// time step can be used once, so a code that matches an already used step
+// returns ErrTOTPReused. Wrong codes count towards a lockout, during which
+// every code is refused with a *TOTPLockedError.
+func VerifyTOTP(db *sql.DB, userID int, code string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		keyID       string
+		sealed      []byte
+		params      TOTPParams
+		lastStep    sql.NullInt64
+		failures    int
+		lockedUntil sql.NullTime
+	)
+	err = tx.QueryRow(`
+		SELECT two_factor_key_id, two_factor_secret_sealed, two_factor_algorithm, two_factor_digits,
+			two_factor_last_step, two_factor_failures, two_factor_locked_until
+		FROM accounts
+		WHERE acc_id = $1 AND two_factor_enabled = true
+		FOR UPDATE
+	`, userID).Scan(&keyID, &sealed, &params.Algorithm, &params.Digits, &lastStep, &failures, &lockedUntil)
+	if err != nil {
+		return false, fmt.Errorf("error loading 2FA state: %v", err)
+	}
+	secret, err := openSecret(userID, keyID, sealed)
+	if err != nil {
+		return false, fmt.Errorf("error loading 2FA secret: %w", err)
+	}
+
+	now := time.Now()
+	if lockedUntil.Valid && now.Before(lockedUntil.Time) {
+		return false, &TOTPLockedError{Until: lockedUntil.Time}
+	}
+
+	step, ok := matchTOTP(secret, code, params, now)
+	if ok && lastStep.Valid && step <= lastStep.Int64 {
+		return false, ErrTOTPReused
+	}
+
+	if ok {
+		_, err = tx.Exec(`
+			UPDATE accounts
+			SET two_factor_last_step = $1, two_factor_failures = 0, two_factor_locked_until = NULL
+			WHERE acc_id = $2
+		`, step, userID)
+	} else {
+		failures++
+		var until *time.Time
+		if lockout := lockoutFor(failures); lockout > 0 {
+			t := now.Add(lockout)
+			until = &t
+		}
+		_, err = tx.Exec(`
+			UPDATE accounts SET two_factor_failures = $1, two_factor_locked_until = $2 WHERE acc_id = $3
+		`, failures, until, userID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error recording 2FA attempt: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// lockoutFor returns how long to lock an account after its nth wrong code
+// in a row.
+func lockoutFor(failures int) time.Duration {
+	over := failures - totpOptions.MaxFailures
+	if over < 0 {
+		return 0
+	}
+	lockout := totpOptions.Lockout
+	for i := 0; i < over && lockout < totpOptions.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > totpOptions.MaxLockout {
+		lockout = totpOptions.MaxLockout
+	}
+	return lockout
+}
+
+// matchTOTP looks for code within the skew window around now and returns
+// the time step it matched.
+func matchTOTP(secret, code string, params TOTPParams, now time.Time) (int64, bool) {
+	if len(code) != params.Digits || strings.Trim(code, "0123456789") != "" {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(TOTPPeriod/time.Second)
+	for delta := -totpOptions.Skew; delta <= totpOptions.Skew; delta++ {
+		step := current + int64(delta)
+		expected := generateTOTP(secret, step, params)
+		if expected < 0 {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(fmt.Sprintf("%0*d", params.Digits, expected)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generateTOTP returns the RFC 6238 code for a time step, or -1 if the
+// secret or parameters are invalid.
+func generateTOTP(secret string, timeWindow int64, params TOTPParams) int {
+	// Decode base32 secret
+	key, err := base32.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return -1
+	}
+
+	var newHash func() hash.Hash
+	switch params.Algorithm {
+	case TOTPSHA1:
+		newHash = sha1.New
+	case TOTPSHA256:
+		newHash = sha256.New
+	case TOTPSHA512:
+		newHash = sha512.New
+	default:
+		return -1
+	}
+	if params.Digits < 1 || params.Digits > 9 {
+		return -1
+	}
+
+	// Create byte array of time
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(timeWindow))
+
+	h := hmac.New(newHash, key)
+	h.Write(buf)
+	sum := h.Sum(nil)
+
+	// Get offset
+	offset := sum[len(sum)-1] & 0xf
+
+	// Generate 4-byte code
+	code := binary.BigEndian.Uint32(sum[offset : offset+4])
+	code &= 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < params.Digits; i++ {
+		mod *= 10
+	}
+	return int(code % mod)
+}
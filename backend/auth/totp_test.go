@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"wira-assignment/db/migrations"
+
+	_ "github.com/lib/pq"
+)
+
+// RFC 6238 Appendix B: the seed is the ASCII string repeated to the hash's
+// output size, and codes have 8 digits.
+var rfc6238Secrets = map[string]string{
+	TOTPSHA1:   "12345678901234567890",
+	TOTPSHA256: "12345678901234567890123456789012",
+	TOTPSHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+}
+
+var rfc6238Vectors = []struct {
+	unix      int64
+	algorithm string
+	code      string
+}{
+	{59, TOTPSHA1, "94287082"},
+	{59, TOTPSHA256, "46119246"},
+	{59, TOTPSHA512, "90693936"},
+	{1111111109, TOTPSHA1, "07081804"},
+	{1111111109, TOTPSHA256, "68084774"},
+	{1111111109, TOTPSHA512, "25091201"},
+	{1111111111, TOTPSHA1, "14050471"},
+	{1111111111, TOTPSHA256, "67062674"},
+	{1111111111, TOTPSHA512, "99943326"},
+	{1234567890, TOTPSHA1, "89005924"},
+	{1234567890, TOTPSHA256, "91819424"},
+	{1234567890, TOTPSHA512, "93441116"},
+	{2000000000, TOTPSHA1, "69279037"},
+	{2000000000, TOTPSHA256, "90698825"},
+	{2000000000, TOTPSHA512, "38618901"},
+	{20000000000, TOTPSHA1, "65353130"},
+	{20000000000, TOTPSHA256, "77737706"},
+	{20000000000, TOTPSHA512, "47863826"},
+}
+
+func TestGenerateTOTPMatchesRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		secret := base32.StdEncoding.EncodeToString([]byte(rfc6238Secrets[v.algorithm]))
+		params := TOTPParams{Algorithm: v.algorithm, Digits: 8}
+		step := v.unix / int64(TOTPPeriod/time.Second)
+
+		if got := fmt.Sprintf("%08d", generateTOTP(secret, step, params)); got != v.code {
+			t.Errorf("%s at %d = %s, want %s", v.algorithm, v.unix, got, v.code)
+		}
+		if got, ok := matchTOTP(secret, v.code, params, time.Unix(v.unix, 0)); !ok || got != step {
+			t.Errorf("matchTOTP(%s at %d) = %d, %v, want step %d", v.algorithm, v.unix, got, ok, step)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	defer SetTOTPOptions(totpOptions)
+	opts := totpOptions
+	opts.Skew = 1
+	SetTOTPOptions(opts)
+
+	secret := base32.StdEncoding.EncodeToString([]byte(rfc6238Secrets[TOTPSHA1]))
+	params := TOTPParams{Algorithm: TOTPSHA1, Digits: 6}
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / int64(TOTPPeriod/time.Second)
+
+	for delta, want := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code := fmt.Sprintf("%06d", generateTOTP(secret, current+delta, params))
+		step, ok := matchTOTP(secret, code, params, now)
+		if ok != want || (ok && step != current+delta) {
+			t.Errorf("code from step %+d = %d, %v, want %v", delta, step, ok, want)
+		}
+	}
+	if _, ok := matchTOTP(secret, "12345", params, now); ok {
+		t.Error("a code with too few digits matched")
+	}
+}
+
+func TestLockoutGrows(t *testing.T) {
+	defer SetTOTPOptions(totpOptions)
+	SetTOTPOptions(TOTPOptions{
+		MaxFailures: 3,
+		Lockout:     30 * time.Second,
+		MaxLockout:  4 * time.Minute,
+	})
+
+	want := []time.Duration{
+		0, 0,
+		30 * time.Second, time.Minute, 2 * time.Minute,
+		4 * time.Minute, 4 * time.Minute,
+	}
+	for i, w := range want {
+		if got := lockoutFor(i + 1); got != w {
+			t.Errorf("lockoutFor(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+// openTestDB returns a freshly migrated database. It needs a Postgres
+// database it is free to wipe, given by TEST_DATABASE_URL.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatalf("error resetting test database: %v", err)
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	ring, err := ParseKeyRing([]string{"test:" + base64.StdEncoding.EncodeToString(key)}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyRing(ring)
+	migrations.RegisterStep(19, SealPlaintextSecrets)
+	migrations.RegisterStep(21, SealAPISigningKeys)
+
+	m, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// enrollTOTP creates an account with TOTP enabled and returns its id and
+// secret.
+func enrollTOTP(t *testing.T, db *sql.DB, username string) (int, string) {
+	t.Helper()
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID int
+	err = db.QueryRow(`
+		INSERT INTO accounts (username, email, password_hash)
+		VALUES ($1, $1 || '@example.com', 'x')
+		RETURNING acc_id
+	`, username).Scan(&userID)
+	if err != nil {
+		t.Fatalf("error creating account: %v", err)
+	}
+	keyID, sealed, err := sealSecret(userID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		UPDATE accounts
+		SET two_factor_enabled = true, two_factor_key_id = $1, two_factor_secret_sealed = $2,
+			two_factor_algorithm = $3, two_factor_digits = 6
+		WHERE acc_id = $4
+	`, keyID, sealed, TOTPSHA1, userID)
+	if err != nil {
+		t.Fatalf("error enrolling account: %v", err)
+	}
+	return userID, secret
+}
+
+func currentCode(secret string) string {
+	step := time.Now().Unix() / int64(TOTPPeriod/time.Second)
+	return fmt.Sprintf("%06d", generateTOTP(secret, step, TOTPParams{Algorithm: TOTPSHA1, Digits: 6}))
+}
+
+func TestVerifyTOTPRejectsReplay(t *testing.T) {
+	db := openTestDB(t)
+	userID, secret := enrollTOTP(t, db, "totp_replay")
+
+	code := currentCode(secret)
+	if ok, err := VerifyTOTP(db, userID, code); !ok || err != nil {
+		t.Fatalf("first VerifyTOTP = %v, %v, want a match", ok, err)
+	}
+	if ok, err := VerifyTOTP(db, userID, code); ok || err != ErrTOTPReused {
+		t.Errorf("replayed VerifyTOTP = %v, %v, want ErrTOTPReused", ok, err)
+	}
+}
+
+func TestVerifyTOTPLocksOut(t *testing.T) {
+	defer SetTOTPOptions(totpOptions)
+	opts := totpOptions
+	opts.MaxFailures = 2
+	opts.Lockout = time.Minute
+	opts.MaxLockout = time.Hour
+	SetTOTPOptions(opts)
+
+	db := openTestDB(t)
+	userID, secret := enrollTOTP(t, db, "totp_lockout")
+
+	wrong := "000000"
+	if wrong == currentCode(secret) {
+		wrong = "000001"
+	}
+	for i := 0; i < opts.MaxFailures; i++ {
+		if ok, err := VerifyTOTP(db, userID, wrong); ok || err != nil {
+			t.Fatalf("wrong code %d = %v, %v", i+1, ok, err)
+		}
+	}
+
+	// While locked even the right code is refused
+	_, err := VerifyTOTP(db, userID, currentCode(secret))
+	var locked *TOTPLockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("VerifyTOTP while locked = %v, want a *TOTPLockedError", err)
+	}
+	if wait := time.Until(locked.Until); wait <= 0 || wait > opts.Lockout {
+		t.Errorf("locked for %s, want up to %s", wait, opts.Lockout)
+	}
+}
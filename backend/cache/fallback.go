@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// FallbackStore serves from a primary store and switches to a secondary one
+// when the primary fails. Watch moves it back once the primary answers
+// pings again.
+type FallbackStore struct {
+	primary   Store
+	secondary Store
+
+	mu       sync.RWMutex
+	degraded bool
+	lastErr  error
+	since    time.Time
+}
+
+func NewFallbackStore(primary, secondary Store) *FallbackStore {
+	return &FallbackStore{primary: primary, secondary: secondary, since: time.Now()}
+}
+
+func (f *FallbackStore) Name() string { return f.primary.Name() }
+
+// current returns the store to use for the next operation
+func (f *FallbackStore) current() Store {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.degraded {
+		return f.secondary
+	}
+	return f.primary
+}
+
+// fail switches to the secondary store after a primary error
+func (f *FallbackStore) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastErr = err
+	if !f.degraded {
+		f.degraded = true
+		f.since = time.Now()
+		log.Printf("Warning: cache %s unavailable, falling back to %s: %v", f.primary.Name(), f.secondary.Name(), err)
+	}
+}
+
+// MarkDown starts the store degraded, for a primary that failed its first
+// connection attempt.
+func (f *FallbackStore) MarkDown(err error) {
+	f.fail(err)
+}
+
+// do runs op against the current store, retrying on the secondary if the
+// primary fails
+func (f *FallbackStore) do(op func(Store) error) error {
+	s := f.current()
+	err := op(s)
+	if err == nil || err == ErrMiss || s != f.primary {
+		return err
+	}
+	f.fail(err)
+	return op(f.secondary)
+}
+
+func (f *FallbackStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var val []byte
+	err := f.do(func(s Store) error {
+		var err error
+		val, err = s.Get(ctx, key)
+		return err
+	})
+	return val, err
+}
+
+func (f *FallbackStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	return f.do(func(s Store) error { return s.Set(ctx, key, value, expiration) })
+}
+
+func (f *FallbackStore) Delete(ctx context.Context, keys ...string) error {
+	return f.do(func(s Store) error { return s.Delete(ctx, keys...) })
+}
+
+func (f *FallbackStore) DeletePattern(ctx context.Context, pattern string) error {
+	return f.do(func(s Store) error { return s.DeletePattern(ctx, pattern) })
+}
+
+func (f *FallbackStore) Incr(ctx context.Context, key string) (int64, error) {
+	var n int64
+	err := f.do(func(s Store) error {
+		var err error
+		n, err = s.Incr(ctx, key)
+		return err
+	})
+	return n, err
+}
+
+func (f *FallbackStore) Ping(ctx context.Context) error {
+	return f.current().Ping(ctx)
+}
+
+// Watch pings the primary store every interval while degraded and switches
+// back to it when it recovers. Entries cached in the primary before the
+// outage are left to their TTLs. Watch returns when ctx is done.
+func (f *FallbackStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		f.mu.RLock()
+		degraded := f.degraded
+		f.mu.RUnlock()
+		if !degraded {
+			continue
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := f.primary.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			f.mu.Lock()
+			f.lastErr = err
+			f.mu.Unlock()
+			continue
+		}
+
+		f.mu.Lock()
+		f.degraded = false
+		f.since = time.Now()
+		f.mu.Unlock()
+		// Drop what was cached locally so a later outage starts clean
+		f.secondary.DeletePattern(ctx, "*")
+		log.Printf("Cache %s recovered", f.primary.Name())
+	}
+}
+
+func (f *FallbackStore) Status(ctx context.Context) Status {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	since := f.since
+	status := Status{
+		Backend:  f.primary.Name(),
+		Healthy:  !f.degraded,
+		Degraded: f.degraded,
+		Since:    &since,
+	}
+	if f.degraded {
+		status.Fallback = f.secondary.Name()
+	}
+	if f.lastErr != nil {
+		status.LastError = f.lastErr.Error()
+	}
+	return status
+}
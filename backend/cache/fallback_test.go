@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStore stands in for Redis: every operation fails while down is set.
+type flakyStore struct {
+	*MemoryStore
+	mu   sync.Mutex
+	down bool
+}
+
+var errDown = errors.New("connection refused")
+
+func (f *flakyStore) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyStore) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errDown
+	}
+	return nil
+}
+
+func (f *flakyStore) Name() string { return "redis" }
+
+func (f *flakyStore) Ping(ctx context.Context) error { return f.err() }
+
+func (f *flakyStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := f.err(); err != nil {
+		return nil, err
+	}
+	return f.MemoryStore.Get(ctx, key)
+}
+
+func (f *flakyStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	if err := f.err(); err != nil {
+		return err
+	}
+	return f.MemoryStore.Set(ctx, key, value, expiration)
+}
+
+func TestFallbackStoreSwitchesToLocalOnError(t *testing.T) {
+	ctx := context.Background()
+	primary := &flakyStore{MemoryStore: NewMemoryStore(10)}
+	local := NewMemoryStore(10)
+	f := NewFallbackStore(primary, local)
+
+	if err := f.Set(ctx, "a", []byte("1"), 0); err != nil {
+		t.Fatalf("Set while healthy = %v", err)
+	}
+	if _, err := local.Get(ctx, "a"); err != ErrMiss {
+		t.Errorf("healthy store wrote to the fallback")
+	}
+
+	primary.setDown(true)
+	if err := f.Set(ctx, "b", []byte("2"), 0); err != nil {
+		t.Fatalf("Set while Redis is down = %v, want the fallback to absorb it", err)
+	}
+	if val, err := local.Get(ctx, "b"); err != nil || string(val) != "2" {
+		t.Errorf("fallback Get(b) = %q, %v, want 2", val, err)
+	}
+	if val, err := f.Get(ctx, "b"); err != nil || string(val) != "2" {
+		t.Errorf("Get(b) while degraded = %q, %v, want 2", val, err)
+	}
+
+	status := f.Status(ctx)
+	if !status.Degraded || status.Healthy || status.Fallback != "memory" || status.LastError != errDown.Error() {
+		t.Errorf("Status while degraded = %+v", status)
+	}
+}
+
+func TestFallbackStoreRecovers(t *testing.T) {
+	primary := &flakyStore{MemoryStore: NewMemoryStore(10)}
+	local := NewMemoryStore(10)
+	f := NewFallbackStore(primary, local)
+	f.MarkDown(errDown)
+	local.Set(context.Background(), "stale", []byte("1"), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Watch(ctx, 5*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for f.Status(ctx).Degraded {
+		if time.Now().After(deadline) {
+			t.Fatal("store did not switch back to the primary")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := local.Get(ctx, "stale"); err != ErrMiss {
+		t.Errorf("fallback entries were kept after recovery")
+	}
+}
+
+func TestClearAllLeavesOtherKeys(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore(10)
+	prev := store
+	Use(m)
+	defer Use(prev)
+
+	Set(ctx, "rankings:1", []int{1}, 0)
+	BumpGeneration(ctx, "rankings")
+	m.Set(ctx, "{leaderboard}:1", []byte("1"), 0)
+	m.Incr(ctx, "ratelimit:apikey:gk_1:1")
+
+	if err := ClearAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	if err := Get(ctx, "rankings:1", &got); err != ErrMiss {
+		t.Errorf("Get(rankings:1) after ClearAll = %v, want ErrMiss", err)
+	}
+	for _, key := range []string{"{leaderboard}:1", "ratelimit:apikey:gk_1:1"} {
+		if _, err := m.Get(ctx, key); err != nil {
+			t.Errorf("ClearAll removed %s", key)
+		}
+	}
+}
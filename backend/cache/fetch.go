@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// TTL controls how long a fetched value is served. Until Soft it is fresh.
+// Between Soft and Hard it is stale: callers still get it while one of them
+// reloads it in the background. After Hard it is gone and callers wait for
+// a reload, which only one of them performs.
+type TTL struct {
+	Soft time.Duration
+	Hard time.Duration
+}
+
+// refreshTimeout bounds a background reload of a stale value
+const refreshTimeout = 30 * time.Second
+
+// envelope wraps a cached value with the time it goes stale
+type envelope struct {
+	Value      json.RawMessage `json:"v"`
+	FreshUntil time.Time       `json:"f"`
+}
+
+// call is one in-flight load shared by every caller of the same key
+type call struct {
+	done chan struct{}
+	val  []byte
+	err  error
+}
+
+var (
+	flightMu sync.Mutex
+	inFlight = make(map[string]*call)
+)
+
+// Fetch reads key into dest, loading it with load when it is missing. Loads
+// for the same key are coalesced within the process, so an expiring key
+// costs one query rather than one per waiting request.
+func Fetch(ctx context.Context, key string, ttl TTL, dest interface{}, load func(ctx context.Context) (interface{}, error)) error {
+	key = KeyPrefix + key
+	if raw, err := store.Get(ctx, key); err == nil {
+		var env envelope
+		if err := json.Unmarshal(raw, &env); err == nil {
+			if time.Now().After(env.FreshUntil) {
+				go refresh(key, ttl, load)
+			}
+			return json.Unmarshal(env.Value, dest)
+		}
+	}
+
+	// Shared loads must not fail because the first caller went away
+	val, err := do(key, func() ([]byte, error) {
+		return loadAndStore(context.WithoutCancel(ctx), key, ttl, load)
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(val, dest)
+}
+
+// do runs fn once per key at a time; concurrent callers wait for and share
+// its result.
+func do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	flightMu.Lock()
+	if c, ok := inFlight[key]; ok {
+		flightMu.Unlock()
+		<-c.done
+		return c.val, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	inFlight[key] = c
+	flightMu.Unlock()
+
+	c.val, c.err = fn()
+
+	flightMu.Lock()
+	delete(inFlight, key)
+	flightMu.Unlock()
+	close(c.done)
+	return c.val, c.err
+}
+
+// refresh reloads a stale key unless a load for it is already running.
+func refresh(key string, ttl TTL, load func(ctx context.Context) (interface{}, error)) {
+	flightMu.Lock()
+	_, busy := inFlight[key]
+	flightMu.Unlock()
+	if busy {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	if _, err := do(key, func() ([]byte, error) { return loadAndStore(ctx, key, ttl, load) }); err != nil {
+		log.Printf("Warning: Failed to refresh %s: %v", key, err)
+	}
+}
+
+func loadAndStore(ctx context.Context, key string, ttl TTL, load func(ctx context.Context) (interface{}, error)) ([]byte, error) {
+	v, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	val, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	hard := ttl.Hard
+	if hard < ttl.Soft {
+		hard = ttl.Soft
+	}
+	data, err := json.Marshal(envelope{Value: val, FreshUntil: time.Now().Add(ttl.Soft)})
+	if err == nil {
+		err = store.Set(ctx, key, data, hard)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to cache %s: %v", key, err)
+	}
+	return val, nil
+}
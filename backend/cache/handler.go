@@ -0,0 +1,15 @@
+package cache
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes mounts the cache health endpoint. It answers 200 while the
+// cache is degraded, since requests are still served.
+func RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/health/cache", func(c *gin.Context) {
+		c.JSON(http.StatusOK, Health(c.Request.Context()))
+	})
+}
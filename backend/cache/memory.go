@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxEntries bounds the in-process cache when no size is configured
+const DefaultMaxEntries = 10000
+
+// MemoryStore is an in-process LRU cache. Expired entries are dropped when
+// read or evicted. Counters live outside the LRU so a generation is never
+// evicted back to an old value.
+type MemoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	items      map[string]*list.Element
+	counters   map[string]int64
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+		counters:   make(map[string]int64),
+	}
+}
+
+func (m *MemoryStore) Name() string { return "memory" }
+
+func (m *MemoryStore) Ping(ctx context.Context) error { return nil }
+
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n, ok := m.counters[key]; ok {
+		return []byte(strconv.FormatInt(n, 10)), nil
+	}
+
+	el, ok := m.items[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	entry := el.Value.(*memoryEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		m.remove(el)
+		return nil, ErrMiss
+	}
+	m.order.MoveToFront(el)
+	return entry.value, nil
+}
+
+func (m *MemoryStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expiresAt time.Time
+	if expiration > 0 {
+		expiresAt = time.Now().Add(expiration)
+	}
+	delete(m.counters, key)
+
+	if el, ok := m.items[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		m.order.MoveToFront(el)
+		return nil
+	}
+
+	m.items[key] = m.order.PushFront(&memoryEntry{key: key, value: value, expiresAt: expiresAt})
+	for m.order.Len() > m.maxEntries {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+func (m *MemoryStore) remove(el *list.Element) {
+	m.order.Remove(el)
+	delete(m.items, el.Value.(*memoryEntry).key)
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.counters, key)
+		if el, ok := m.items[key]; ok {
+			m.remove(el)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeletePattern(ctx context.Context, pattern string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, el := range m.items {
+		if globMatch(pattern, key) {
+			m.remove(el)
+		}
+	}
+	for key := range m.counters {
+		if globMatch(pattern, key) {
+			delete(m.counters, key)
+		}
+	}
+	return nil
+}
+
+// globMatch matches key against a Redis glob pattern. path.Match stops '*'
+// at '/', which Redis does not, so slashes are swapped out first.
+func globMatch(pattern, key string) bool {
+	ok, _ := path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(key, "/", "\x00"))
+	return ok
+}
+
+func (m *MemoryStore) Incr(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		n, _ := strconv.ParseInt(string(el.Value.(*memoryEntry).value), 10, 64)
+		m.counters[key] = n
+		m.remove(el)
+	}
+	m.counters[key]++
+	return m.counters[key], nil
+}
+
+// NoopStore caches nothing.
+type NoopStore struct{}
+
+func (NoopStore) Name() string { return "none" }
+
+func (NoopStore) Ping(ctx context.Context) error { return nil }
+
+func (NoopStore) Get(ctx context.Context, key string) ([]byte, error) { return nil, ErrMiss }
+
+func (NoopStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	return nil
+}
+
+func (NoopStore) Delete(ctx context.Context, keys ...string) error { return nil }
+
+func (NoopStore) DeletePattern(ctx context.Context, pattern string) error { return nil }
+
+func (NoopStore) Incr(ctx context.Context, key string) (int64, error) { return 0, nil }
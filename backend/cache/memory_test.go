@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore(2)
+
+	m.Set(ctx, "a", []byte("1"), 0)
+	m.Set(ctx, "b", []byte("2"), 0)
+	// Reading a makes b the least recently used
+	if _, err := m.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get(a) = %v", err)
+	}
+	m.Set(ctx, "c", []byte("3"), 0)
+
+	if _, err := m.Get(ctx, "b"); err != ErrMiss {
+		t.Errorf("Get(b) after eviction = %v, want ErrMiss", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := m.Get(ctx, key); err != nil {
+			t.Errorf("Get(%s) = %v, want a hit", key, err)
+		}
+	}
+}
+
+func TestMemoryStoreExpires(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore(10)
+
+	m.Set(ctx, "short", []byte("1"), 10*time.Millisecond)
+	m.Set(ctx, "forever", []byte("2"), 0)
+	if _, err := m.Get(ctx, "short"); err != nil {
+		t.Fatalf("Get(short) before expiry = %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := m.Get(ctx, "short"); err != ErrMiss {
+		t.Errorf("Get(short) after expiry = %v, want ErrMiss", err)
+	}
+	if _, err := m.Get(ctx, "forever"); err != nil {
+		t.Errorf("Get(forever) = %v, want a hit", err)
+	}
+}
+
+func TestMemoryStoreCountersAreNotEvicted(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore(1)
+
+	m.Incr(ctx, "gen")
+	m.Incr(ctx, "gen")
+	m.Set(ctx, "a", []byte("1"), 0)
+	m.Set(ctx, "b", []byte("2"), 0)
+
+	val, err := m.Get(ctx, "gen")
+	if err != nil || string(val) != "2" {
+		t.Errorf("Get(gen) = %q, %v, want 2", val, err)
+	}
+}
+
+func TestMemoryStoreDeletePattern(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore(10)
+
+	m.Set(ctx, "cache:rankings:a/b", []byte("1"), 0)
+	m.Incr(ctx, "cache:gen:rankings")
+	m.Set(ctx, "other", []byte("2"), 0)
+
+	m.DeletePattern(ctx, "cache:*")
+	for _, key := range []string{"cache:rankings:a/b", "cache:gen:rankings"} {
+		if _, err := m.Get(ctx, key); err != ErrMiss {
+			t.Errorf("Get(%s) = %v, want ErrMiss", key, err)
+		}
+	}
+	if _, err := m.Get(ctx, "other"); err != nil {
+		t.Errorf("Get(other) = %v, want a hit", err)
+	}
+}
@@ -2,26 +2,61 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-var redisClient *redis.Client
+// Redis deployment modes
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
 
-// Initialize Redis client
-func InitRedis(host string, port string) error {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", host, "6379"),
-		Password: "", 
-		DB:       0,  
-	})
+// RedisOptions describes how to reach Redis. Addrs holds the server address
+// in standalone mode, the sentinels in sentinel mode and the seed nodes in
+// cluster mode.
+type RedisOptions struct {
+	Mode       string
+	Addrs      []string
+	MasterName string
+
+	Username         string
+	Password         string
+	SentinelPassword string
+	DB               int
+
+	// TLS is enabled by TLS or by setting TLSCAFile, a PEM bundle trusted in
+	// addition to the system roots
+	TLS           bool
+	TLSCAFile     string
+	TLSServerName string
+
+	PoolSize     int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+var redisClient redis.UniversalClient
+
+// InitRedis connects to Redis and checks the connection.
+func InitRedis(opts RedisOptions) error {
+	client, err := NewRedisClient(opts)
+	if err != nil {
+		return err
+	}
+	redisClient = client
 
 	// Test connection
 	ctx := context.Background()
-	_, err := redisClient.Ping(ctx).Result()
+	_, err = redisClient.Ping(ctx).Result()
 	if err != nil {
 		return fmt.Errorf("failed to connect to Redis: %v", err)
 	}
@@ -29,58 +64,163 @@ func InitRedis(host string, port string) error {
 	return nil
 }
 
-// Get cached data
-func Get(ctx context.Context, key string, dest interface{}) error {
-	val, err := redisClient.Get(ctx, key).Result()
-	if err == redis.Nil {
-		return fmt.Errorf("key does not exist")
-	} else if err != nil {
-		return err
+// NewRedisClient builds a client for opts without connecting.
+func NewRedisClient(opts RedisOptions) (redis.UniversalClient, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, fmt.Errorf("no Redis address configured")
 	}
 
-	return json.Unmarshal([]byte(val), dest)
-}
+	var tlsConfig *tls.Config
+	if opts.TLS || opts.TLSCAFile != "" {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: opts.TLSServerName}
+		if opts.TLSCAFile != "" {
+			pem, err := os.ReadFile(opts.TLSCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read Redis CA file: %v", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in Redis CA file %s", opts.TLSCAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
 
-// Set data in cache
-func Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
-	data, err := json.Marshal(value)
-	if err != nil {
-		return err
+	universal := &redis.UniversalOptions{
+		Addrs:            opts.Addrs,
+		MasterName:       opts.MasterName,
+		Username:         opts.Username,
+		Password:         opts.Password,
+		SentinelPassword: opts.SentinelPassword,
+		DB:               opts.DB,
+		TLSConfig:        tlsConfig,
+		PoolSize:         opts.PoolSize,
+		DialTimeout:      opts.DialTimeout,
+		ReadTimeout:      opts.ReadTimeout,
+		WriteTimeout:     opts.WriteTimeout,
 	}
 
-	return redisClient.Set(ctx, key, data, expiration).Err()
+	switch opts.Mode {
+	case "", RedisStandalone:
+		if len(opts.Addrs) > 1 {
+			return nil, fmt.Errorf("standalone Redis takes one address, got %d", len(opts.Addrs))
+		}
+		return redis.NewClient(universal.Simple()), nil
+	case RedisSentinel:
+		if opts.MasterName == "" {
+			return nil, fmt.Errorf("sentinel mode requires a master name")
+		}
+		return redis.NewFailoverClient(universal.Failover()), nil
+	case RedisCluster:
+		if opts.DB != 0 {
+			return nil, fmt.Errorf("Redis Cluster only supports DB 0")
+		}
+		return redis.NewClusterClient(universal.Cluster()), nil
+	}
+	return nil, fmt.Errorf("unknown Redis mode: %s", opts.Mode)
+}
+
+// Client returns the shared Redis client, or nil before InitRedis
+func Client() redis.UniversalClient {
+	return redisClient
 }
 
-// Delete cached data
-func Delete(ctx context.Context, key string) error {
-	return redisClient.Del(ctx, key).Err()
+// ScanKeys calls fn with batches of keys matching pattern. In cluster mode
+// every master is scanned; fn is never called concurrently.
+func ScanKeys(ctx context.Context, rdb redis.UniversalClient, pattern string, fn func(keys []string) error) error {
+	switch c := rdb.(type) {
+	case *redis.ClusterClient:
+		var mu sync.Mutex
+		locked := func(keys []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(keys)
+		}
+		return c.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node, pattern, locked)
+		})
+	case *redis.Client:
+		return scanNode(ctx, c, pattern, fn)
+	}
+	return fmt.Errorf("unsupported Redis client %T", rdb)
 }
 
-// Clear cache by pattern
-func ClearByPattern(ctx context.Context, pattern string) error {
-	iter := redisClient.Scan(ctx, 0, pattern, 0).Iterator()
+func scanNode(ctx context.Context, c *redis.Client, pattern string, fn func(keys []string) error) error {
+	iter := c.Scan(ctx, 0, pattern, 1000).Iterator()
+	batch := make([]string, 0, 1000)
 	for iter.Next(ctx) {
-		err := redisClient.Del(ctx, iter.Val()).Err()
-		if err != nil {
-			return err
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
 		}
 	}
-	return iter.Err()
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan keys: %v", err)
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// RedisStore caches in Redis.
+type RedisStore struct {
+	rdb redis.UniversalClient
+}
+
+func NewRedisStore(rdb redis.UniversalClient) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+func (s *RedisStore) Name() string { return "redis" }
+
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.rdb.Ping(ctx).Err()
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := s.rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	return val, err
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	return s.rdb.Set(ctx, key, value, expiration).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	return s.unlink(ctx, keys)
+}
+
+// DeletePattern walks keys with SCAN so a large keyspace does not block the
+// server, and unlinks them in batches.
+func (s *RedisStore) DeletePattern(ctx context.Context, pattern string) error {
+	return ScanKeys(ctx, s.rdb, pattern, func(keys []string) error {
+		return s.unlink(ctx, keys)
+	})
+}
+
+// unlink removes keys with one command per key in a single pipeline, since
+// a multi-key UNLINK fails in cluster mode when the keys span slots.
+func (s *RedisStore) unlink(ctx context.Context, keys []string) error {
+	pipe := s.rdb.Pipeline()
+	for _, key := range keys {
+		pipe.Unlink(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete keys: %v", err)
+	}
+	return nil
 }
 
-// ClearAll clears all keys in the Redis cache
-func ClearAll(ctx context.Context) error {
-    keys, err := redisClient.Keys(ctx, "*").Result()
-    if err != nil {
-        return fmt.Errorf("failed to get keys: %v", err)
-    }
-
-    if len(keys) > 0 {
-        err = redisClient.Del(ctx, keys...).Err()
-        if err != nil {
-            return fmt.Errorf("failed to delete keys: %v", err)
-        }
-    }
-
-    return nil
+func (s *RedisStore) Incr(ctx context.Context, key string) (int64, error) {
+	return s.rdb.Incr(ctx, key).Result()
 }
@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrMiss is returned by Store.Get for keys that are absent or expired.
+var ErrMiss = errors.New("key does not exist")
+
+// Store is a byte-oriented cache backend. Patterns use Redis glob syntax.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	DeletePattern(ctx context.Context, pattern string) error
+	Incr(ctx context.Context, key string) (int64, error)
+	Ping(ctx context.Context) error
+	Name() string
+}
+
+// Status describes the cache backend in use.
+type Status struct {
+	Backend   string     `json:"backend"`
+	Healthy   bool       `json:"healthy"`
+	Degraded  bool       `json:"degraded"`
+	Fallback  string     `json:"fallback,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Since     *time.Time `json:"since,omitempty"`
+}
+
+// statusReporter is implemented by stores that track their own health
+type statusReporter interface {
+	Status(ctx context.Context) Status
+}
+
+// KeyPrefix namespaces the keys written by the package-level helpers. The
+// Redis keyspace is shared with the leaderboard and rate limiter, so the
+// helpers only ever read, write or clear keys under it.
+const KeyPrefix = "cache:"
+
+// store backs the package-level helpers. It starts as an in-process cache so
+// code paths that cache work before, or without, any configuration.
+var store Store = NewMemoryStore(DefaultMaxEntries)
+
+// Use replaces the store behind the package-level helpers.
+func Use(s Store) {
+	store = s
+}
+
+// Health reports on the store in use.
+func Health(ctx context.Context) Status {
+	if r, ok := store.(statusReporter); ok {
+		return r.Status(ctx)
+	}
+	err := store.Ping(ctx)
+	status := Status{Backend: store.Name(), Healthy: err == nil}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	return status
+}
+
+// Get cached data
+func Get(ctx context.Context, key string, dest interface{}) error {
+	val, err := store.Get(ctx, KeyPrefix+key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(val, dest)
+}
+
+// Set data in cache
+func Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return store.Set(ctx, KeyPrefix+key, data, expiration)
+}
+
+// Delete cached data
+func Delete(ctx context.Context, key string) error {
+	return store.Delete(ctx, KeyPrefix+key)
+}
+
+// Clear cache by pattern
+func ClearByPattern(ctx context.Context, pattern string) error {
+	return store.DeletePattern(ctx, KeyPrefix+pattern)
+}
+
+// ClearAll clears every key in the cache, leaving other data in the store
+// alone
+func ClearAll(ctx context.Context) error {
+	return store.DeletePattern(ctx, KeyPrefix+"*")
+}
+
+// Generation returns the current generation of a tag. Keys that embed it go
+// stale as soon as the tag is bumped and are left to expire.
+func Generation(ctx context.Context, tag string) (int64, error) {
+	val, err := store.Get(ctx, KeyPrefix+"gen:"+tag)
+	if err == ErrMiss {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var gen int64
+	err = json.Unmarshal(val, &gen)
+	return gen, err
+}
+
+// BumpGeneration moves tags to a new generation, invalidating every key
+// built from their previous ones.
+func BumpGeneration(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		if _, err := store.Incr(ctx, KeyPrefix+"gen:"+tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backends selectable by configuration
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendNone   = "none"
+)
+
+// NewLocalStore returns the in-process store for a backend name.
+func NewLocalStore(backend string, maxEntries int) (Store, error) {
+	switch backend {
+	case BackendMemory:
+		return NewMemoryStore(maxEntries), nil
+	case BackendNone:
+		return NoopStore{}, nil
+	}
+	return nil, fmt.Errorf("unknown local cache backend: %s", backend)
+}
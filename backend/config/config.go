@@ -1,62 +1,310 @@
 package config
 
 import (
+    "errors"
     "fmt"
-    "os"
-
-    "github.com/joho/godotenv"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
 )
 
+// Config is the server configuration. It is built from defaults, then
+// config.yaml, then environment variables, then command-line flags, each
+// layer overriding the one before; see Load.
 type Config struct {
-    DBHost     string
-    DBPort     string
-    DBUser     string
-    DBPassword string
-    DBName     string
-    DBSSLMode  string
-    JWTSecret  string
-    RedisHost  string
-    RedisPort  string
-    RedisPassword string
-    ServerPort    string
+    Server    ServerConfig    `yaml:"server"`
+    Database  DatabaseConfig  `yaml:"database"`
+    Redis     RedisConfig     `yaml:"redis"`
+    Cache     CacheConfig     `yaml:"cache"`
+    JWT       JWTConfig       `yaml:"jwt"`
+    Session   SessionConfig   `yaml:"session"`
+    TwoFactor TwoFactorConfig `yaml:"two_factor"`
+}
+
+type ServerConfig struct {
+    Port string     `yaml:"port"`
+    CORS CORSConfig `yaml:"cors"`
+}
+
+type CORSConfig struct {
+    AllowedOrigins []string      `yaml:"allowed_origins"`
+    AllowedMethods []string      `yaml:"allowed_methods"`
+    AllowedHeaders []string      `yaml:"allowed_headers"`
+    MaxAge         time.Duration `yaml:"max_age"`
+}
+
+type DatabaseConfig struct {
+    Host         string `yaml:"host"`
+    Port         string `yaml:"port"`
+    User         string `yaml:"user"`
+    Password     string `yaml:"password"`
+    PasswordFile string `yaml:"password_file"`
+    Name         string `yaml:"name"`
+    SSLMode      string `yaml:"sslmode"`
+
+    MaxOpenConns    int           `yaml:"max_open_conns"`
+    MaxIdleConns    int           `yaml:"max_idle_conns"`
+    ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+
+    // AutoMigrate applies pending migrations when the server starts
+    AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+// RedisConfig describes the Redis deployment. Mode is "standalone",
+// "sentinel" or "cluster". Addrs overrides Host and Port and lists the
+// sentinels or cluster seed nodes in those modes.
+type RedisConfig struct {
+    Mode       string   `yaml:"mode"`
+    Host       string   `yaml:"host"`
+    Port       string   `yaml:"port"`
+    Addrs      []string `yaml:"addrs"`
+    MasterName string   `yaml:"master_name"`
+
+    Username             string `yaml:"username"`
+    Password             string `yaml:"password"`
+    PasswordFile         string `yaml:"password_file"`
+    SentinelPassword     string `yaml:"sentinel_password"`
+    SentinelPasswordFile string `yaml:"sentinel_password_file"`
+    DB                   int    `yaml:"db"`
+
+    TLS           bool   `yaml:"tls"`
+    TLSCAFile     string `yaml:"tls_ca_file"`
+    TLSServerName string `yaml:"tls_server_name"`
+
+    PoolSize     int           `yaml:"pool_size"`
+    DialTimeout  time.Duration `yaml:"dial_timeout"`
+    ReadTimeout  time.Duration `yaml:"read_timeout"`
+    WriteTimeout time.Duration `yaml:"write_timeout"`
+}
+
+// CacheConfig selects the cache store. Backend is "redis", "memory" or
+// "none"; Fallback is what a Redis cache degrades to when Redis is
+// unreachable, "memory" or "none". Live ranking pages are served fresh for
+// SoftTTL, then served stale while one request refreshes them, until HardTTL.
+type CacheConfig struct {
+    Backend    string        `yaml:"backend"`
+    Fallback   string        `yaml:"fallback"`
+    MaxEntries int           `yaml:"max_entries"`
+    SoftTTL    time.Duration `yaml:"soft_ttl"`
+    HardTTL    time.Duration `yaml:"hard_ttl"`
 }
 
-func LoadConfig() (*Config, error) {
-    err := godotenv.Load()
-    if err != nil {
-        return nil, fmt.Errorf("error loading .env file: %v", err)
+type JWTConfig struct {
+    Secret     string        `yaml:"secret"`
+    SecretFile string        `yaml:"secret_file"`
+    Expiry     time.Duration `yaml:"expiry"`
+}
+
+type SessionConfig struct {
+    TTL time.Duration `yaml:"ttl"`
+}
+
+// TwoFactorConfig controls TOTP codes. Digits and Algorithm (SHA1, SHA256
+// or SHA512) apply to new enrollments. Skew is how many 30-second steps
+// either side of the current one are accepted. After MaxFailures wrong codes
+// in a row an account is locked for Lockout, doubling with each further
+// failure up to MaxLockout.
+//
+// Secrets are stored encrypted with EncryptionKeys, each "id:key" with a
+// base64 32-byte key. New secrets use ActiveKey, or the first key if unset;
+// the others decrypt secrets stored before a rotation.
+type TwoFactorConfig struct {
+    Digits      int           `yaml:"digits"`
+    Algorithm   string        `yaml:"algorithm"`
+    Skew        int           `yaml:"skew"`
+    MaxFailures int           `yaml:"max_failures"`
+    Lockout     time.Duration `yaml:"lockout"`
+    MaxLockout  time.Duration `yaml:"max_lockout"`
+
+    EncryptionKeys     []string `yaml:"encryption_keys"`
+    EncryptionKeysFile string   `yaml:"encryption_keys_file"`
+    ActiveKey          string   `yaml:"active_key"`
+}
+
+// Default returns the configuration used for anything left unset.
+func Default() *Config {
+    return &Config{
+        Server: ServerConfig{
+            Port: "8080",
+            CORS: CORSConfig{
+                AllowedOrigins: []string{"http://localhost:3000", "http://77.237.243.104:3000", "https://wira.aizat.dev"},
+                AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+                AllowedHeaders: []string{"Content-Type", "Authorization", "x-session-id"},
+                MaxAge:         12 * time.Hour,
+            },
+        },
+        Database: DatabaseConfig{
+            Port:            "5432",
+            SSLMode:         "disable",
+            MaxOpenConns:    25,
+            MaxIdleConns:    25,
+            ConnMaxLifetime: 5 * time.Minute,
+        },
+        Redis: RedisConfig{
+            Mode: "standalone",
+            Port: "6379",
+        },
+        Cache: CacheConfig{
+            Backend:  "redis",
+            Fallback: "memory",
+            SoftTTL:  time.Minute,
+            HardTTL:  5 * time.Minute,
+        },
+        JWT: JWTConfig{
+            Expiry: 5 * time.Minute,
+        },
+        Session: SessionConfig{
+            TTL: 5 * time.Minute,
+        },
+        TwoFactor: TwoFactorConfig{
+            Digits:      6,
+            Algorithm:   "SHA1",
+            Skew:        1,
+            MaxFailures: 5,
+            Lockout:     30 * time.Second,
+            MaxLockout:  15 * time.Minute,
+        },
     }
+}
 
-    config := &Config{
-        DBHost:     os.Getenv("DB_HOST"),
-        DBPort:     os.Getenv("DB_PORT"),
-        DBUser:     os.Getenv("DB_USER"),
-        DBPassword: os.Getenv("DB_PASSWORD"),
-        DBName:     os.Getenv("DB_NAME"),
-        DBSSLMode:  os.Getenv("DB_SSLMODE"),
-        JWTSecret:  os.Getenv("JWT_SECRET"),
-        RedisHost:  os.Getenv("REDIS_HOST"),
-        RedisPort:  os.Getenv("REDIS_PORT"),
-        RedisPassword: os.Getenv("REDIS_PASSWORD"),
-        ServerPort:    os.Getenv("SERVER_PORT"),
+// RedisAddrs returns the configured Redis addresses.
+func (c *Config) RedisAddrs() []string {
+    if len(c.Redis.Addrs) > 0 {
+        return c.Redis.Addrs
     }
+    if c.Redis.Host == "" {
+        return nil
+    }
+    return []string{net.JoinHostPort(c.Redis.Host, c.Redis.Port)}
+}
 
-    // Set default server port if not specified
-    if config.ServerPort == "" {
-        config.ServerPort = "8080"
+// Validate reports every invalid or missing setting at once.
+func (c *Config) Validate() error {
+    var errs []error
+    fail := func(format string, args ...interface{}) {
+        errs = append(errs, fmt.Errorf(format, args...))
     }
 
-    return config, nil
+    if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+        fail("server.port: %q is not a valid port", c.Server.Port)
+    }
+    if len(c.Server.CORS.AllowedOrigins) == 0 {
+        fail("server.cors.allowed_origins: at least one origin is required")
+    }
+
+    c.validateDatabase(fail)
+
+    if c.JWT.Secret == "" {
+        fail("jwt.secret is required (JWT_SECRET or JWT_SECRET_FILE)")
+    }
+    if c.JWT.Expiry <= 0 {
+        fail("jwt.expiry must be positive")
+    }
+    if c.Session.TTL <= 0 {
+        fail("session.ttl must be positive")
+    }
+
+    if c.TwoFactor.Digits < 6 || c.TwoFactor.Digits > 8 {
+        fail("two_factor.digits must be between 6 and 8")
+    }
+    switch c.TwoFactor.Algorithm {
+    case "SHA1", "SHA256", "SHA512":
+    default:
+        fail("two_factor.algorithm: unknown algorithm %q", c.TwoFactor.Algorithm)
+    }
+    if c.TwoFactor.Skew < 0 || c.TwoFactor.Skew > 10 {
+        fail("two_factor.skew must be between 0 and 10")
+    }
+    if c.TwoFactor.MaxFailures < 1 {
+        fail("two_factor.max_failures must be at least 1")
+    }
+    if c.TwoFactor.Lockout <= 0 {
+        fail("two_factor.lockout must be positive")
+    }
+    if c.TwoFactor.MaxLockout < c.TwoFactor.Lockout {
+        fail("two_factor.max_lockout must not be shorter than two_factor.lockout")
+    }
+    if len(c.TwoFactor.EncryptionKeys) == 0 {
+        fail("two_factor.encryption_keys is required (TOTP_ENCRYPTION_KEYS or TOTP_ENCRYPTION_KEYS_FILE)")
+    }
+    keyIDs := make(map[string]bool)
+    for _, key := range c.TwoFactor.EncryptionKeys {
+        id, _, ok := strings.Cut(key, ":")
+        if !ok || id == "" {
+            fail("two_factor.encryption_keys: entries must look like id:base64key")
+            continue
+        }
+        keyIDs[id] = true
+    }
+    if c.TwoFactor.ActiveKey != "" && !keyIDs[c.TwoFactor.ActiveKey] {
+        fail("two_factor.active_key: no key with ID %q in two_factor.encryption_keys", c.TwoFactor.ActiveKey)
+    }
+
+    switch c.Cache.Backend {
+    case "redis":
+        if len(c.RedisAddrs()) == 0 {
+            fail("redis.host or redis.addrs is required when cache.backend is redis (REDIS_HOST)")
+        }
+    case "memory", "none":
+    default:
+        fail("cache.backend: unknown backend %q", c.Cache.Backend)
+    }
+    if c.Cache.Fallback != "memory" && c.Cache.Fallback != "none" {
+        fail("cache.fallback: unknown backend %q", c.Cache.Fallback)
+    }
+    if c.Cache.SoftTTL <= 0 {
+        fail("cache.soft_ttl must be positive")
+    }
+    if c.Cache.HardTTL < c.Cache.SoftTTL {
+        fail("cache.hard_ttl must not be shorter than cache.soft_ttl")
+    }
+
+    switch c.Redis.Mode {
+    case "standalone", "sentinel", "cluster":
+    default:
+        fail("redis.mode: unknown mode %q", c.Redis.Mode)
+    }
+    if c.Redis.PoolSize < 0 {
+        fail("redis.pool_size must not be negative")
+    }
+
+    return errors.Join(errs...)
+}
+
+// ValidateDatabase reports every invalid or missing database setting, for
+// commands that need only the database.
+func (c *Config) ValidateDatabase() error {
+    var errs []error
+    c.validateDatabase(func(format string, args ...interface{}) {
+        errs = append(errs, fmt.Errorf(format, args...))
+    })
+    return errors.Join(errs...)
+}
+
+func (c *Config) validateDatabase(fail func(format string, args ...interface{})) {
+    if c.Database.Host == "" {
+        fail("database.host is required (DB_HOST)")
+    }
+    if c.Database.User == "" {
+        fail("database.user is required (DB_USER)")
+    }
+    if c.Database.Name == "" {
+        fail("database.name is required (DB_NAME)")
+    }
+    if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+        fail("database pool sizes must not be negative")
+    }
 }
 
 func (c *Config) GetDBConnString() string {
-    return fmt.Sprintf(
-        "postgres://%s:%s@%s:%s/%s?sslmode=%s",
-        c.DBUser,
-        c.DBPassword,
-        c.DBHost,
-        c.DBPort,
-        c.DBName,
-        c.DBSSLMode,
-    )
+    u := url.URL{
+        Scheme:   "postgres",
+        User:     url.UserPassword(c.Database.User, c.Database.Password),
+        Host:     net.JoinHostPort(c.Database.Host, c.Database.Port),
+        Path:     c.Database.Name,
+        RawQuery: url.Values{"sslmode": {c.Database.SSLMode}}.Encode(),
+    }
+    return u.String()
 }
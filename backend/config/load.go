@@ -0,0 +1,314 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is read when neither -config nor CONFIG_FILE names a file. It
+// is optional; an explicitly named file is not.
+const DefaultFile = "config.yaml"
+
+// Load builds the configuration from, in increasing precedence: defaults,
+// the YAML file, environment variables (including any in an optional .env
+// file) and the flags in args. ${VAR} and ${VAR:-default} in the YAML are
+// expanded from the environment. Secrets may be given as files with the
+// *_file settings or *_FILE variables, which take precedence over inline
+// values.
+//
+// Flags end at the first argument that is not one; it and the arguments
+// after it are returned and name a subcommand. The result is validated
+// before it is returned: in full for the server, and only the database
+// settings for a subcommand, which needs nothing else.
+func Load(args []string) (*Config, []string, error) {
+	cfg, rest, err := load(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	validate := cfg.Validate
+	if len(rest) > 0 {
+		validate = cfg.ValidateDatabase
+	}
+	if err := validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%v", err)
+	}
+	return cfg, rest, nil
+}
+
+func load(args []string) (*Config, []string, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("error loading .env file: %v", err)
+	}
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file (default "+DefaultFile+")")
+	port := flags.String("port", "", "port to listen on")
+	cacheBackend := flags.String("cache-backend", "", "cache backend: redis, memory or none")
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	if err := loadFile(cfg, *path); err != nil {
+		return nil, nil, err
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = *port
+		case "cache-backend":
+			cfg.Cache.Backend = *cacheBackend
+		}
+	})
+
+	if err := readSecrets(cfg); err != nil {
+		return nil, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// loadFile decodes the YAML file at path over cfg. An empty path reads
+// DefaultFile if it exists.
+func loadFile(cfg *Config, path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	expandNode(&root)
+	if err := root.Decode(cfg); err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return nil
+}
+
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expand replaces ${VAR} and ${VAR:-default} in s. It reports whether s held
+// any references.
+func expand(s string) (string, bool) {
+	found := false
+	out := varPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		found = true
+		m := varPattern.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1]); ok && v != "" {
+			return v
+		}
+		return m[3]
+	})
+	return out, found
+}
+
+// expandNode expands variables in every scalar under n. A value that
+// expands to nothing is dropped, so an unset variable leaves the default in
+// place rather than blanking it.
+func expandNode(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			expandNode(c)
+		}
+	case yaml.MappingNode:
+		content := n.Content[:0]
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			if expandScalar(val) {
+				continue
+			}
+			expandNode(val)
+			content = append(content, key, val)
+		}
+		n.Content = content
+	case yaml.SequenceNode:
+		content := n.Content[:0]
+		for _, c := range n.Content {
+			if expandScalar(c) {
+				continue
+			}
+			expandNode(c)
+			content = append(content, c)
+		}
+		n.Content = content
+	}
+}
+
+// expandScalar expands a scalar node in place and reports whether it
+// expanded to an empty value and should be dropped.
+func expandScalar(n *yaml.Node) bool {
+	if n.Kind != yaml.ScalarNode {
+		return false
+	}
+	v, found := expand(n.Value)
+	if !found {
+		return false
+	}
+	n.Value = v
+	if n.Style == 0 {
+		// Let "${DB_PORT}" resolve as the number it expands to
+		n.Tag = ""
+	}
+	return v == ""
+}
+
+// applyEnv overrides cfg with any of its environment variables that are set.
+func applyEnv(cfg *Config) error {
+	vars := []struct {
+		name string
+		dest interface{}
+	}{
+		{"SERVER_PORT", &cfg.Server.Port},
+		{"CORS_ALLOWED_ORIGINS", &cfg.Server.CORS.AllowedOrigins},
+
+		{"DB_HOST", &cfg.Database.Host},
+		{"DB_PORT", &cfg.Database.Port},
+		{"DB_USER", &cfg.Database.User},
+		{"DB_PASSWORD", &cfg.Database.Password},
+		{"DB_PASSWORD_FILE", &cfg.Database.PasswordFile},
+		{"DB_NAME", &cfg.Database.Name},
+		{"DB_SSLMODE", &cfg.Database.SSLMode},
+		{"DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns},
+		{"DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns},
+		{"DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime},
+		{"DB_AUTO_MIGRATE", &cfg.Database.AutoMigrate},
+
+		{"REDIS_MODE", &cfg.Redis.Mode},
+		{"REDIS_HOST", &cfg.Redis.Host},
+		{"REDIS_PORT", &cfg.Redis.Port},
+		{"REDIS_ADDR", &cfg.Redis.Addrs},
+		{"REDIS_MASTER_NAME", &cfg.Redis.MasterName},
+		{"REDIS_USERNAME", &cfg.Redis.Username},
+		{"REDIS_PASSWORD", &cfg.Redis.Password},
+		{"REDIS_PASSWORD_FILE", &cfg.Redis.PasswordFile},
+		{"REDIS_SENTINEL_PASSWORD", &cfg.Redis.SentinelPassword},
+		{"REDIS_SENTINEL_PASSWORD_FILE", &cfg.Redis.SentinelPasswordFile},
+		{"REDIS_DB", &cfg.Redis.DB},
+		{"REDIS_TLS", &cfg.Redis.TLS},
+		{"REDIS_TLS_CA_FILE", &cfg.Redis.TLSCAFile},
+		{"REDIS_TLS_SERVER_NAME", &cfg.Redis.TLSServerName},
+		{"REDIS_POOL_SIZE", &cfg.Redis.PoolSize},
+		{"REDIS_DIAL_TIMEOUT", &cfg.Redis.DialTimeout},
+		{"REDIS_READ_TIMEOUT", &cfg.Redis.ReadTimeout},
+		{"REDIS_WRITE_TIMEOUT", &cfg.Redis.WriteTimeout},
+
+		{"CACHE_BACKEND", &cfg.Cache.Backend},
+		{"CACHE_FALLBACK", &cfg.Cache.Fallback},
+		{"CACHE_MAX_ENTRIES", &cfg.Cache.MaxEntries},
+		{"CACHE_SOFT_TTL", &cfg.Cache.SoftTTL},
+		{"CACHE_HARD_TTL", &cfg.Cache.HardTTL},
+
+		{"JWT_SECRET", &cfg.JWT.Secret},
+		{"JWT_SECRET_FILE", &cfg.JWT.SecretFile},
+		{"JWT_EXPIRY", &cfg.JWT.Expiry},
+		{"SESSION_TTL", &cfg.Session.TTL},
+
+		{"TOTP_DIGITS", &cfg.TwoFactor.Digits},
+		{"TOTP_ALGORITHM", &cfg.TwoFactor.Algorithm},
+		{"TOTP_SKEW", &cfg.TwoFactor.Skew},
+		{"TOTP_MAX_FAILURES", &cfg.TwoFactor.MaxFailures},
+		{"TOTP_LOCKOUT", &cfg.TwoFactor.Lockout},
+		{"TOTP_MAX_LOCKOUT", &cfg.TwoFactor.MaxLockout},
+		{"TOTP_ENCRYPTION_KEYS", &cfg.TwoFactor.EncryptionKeys},
+		{"TOTP_ENCRYPTION_KEYS_FILE", &cfg.TwoFactor.EncryptionKeysFile},
+		{"TOTP_ACTIVE_KEY", &cfg.TwoFactor.ActiveKey},
+	}
+	for _, v := range vars {
+		if err := parseEnv(v.name, v.dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseEnv parses the named variable into dest, leaving dest untouched when
+// the variable is unset. Lists are comma-separated.
+func parseEnv(name string, dest interface{}) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+
+	var err error
+	switch d := dest.(type) {
+	case *string:
+		*d = v
+	case *[]string:
+		*d = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*d = append(*d, item)
+			}
+		}
+	case *int:
+		*d, err = strconv.Atoi(v)
+	case *bool:
+		*d, err = strconv.ParseBool(v)
+	case *time.Duration:
+		*d, err = time.ParseDuration(v)
+	default:
+		err = fmt.Errorf("unsupported type %T", dest)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return nil
+}
+
+// readSecrets replaces secrets with the contents of their files, when set.
+func readSecrets(cfg *Config) error {
+	secrets := []struct {
+		name  string
+		file  string
+		value *string
+	}{
+		{"database.password_file", cfg.Database.PasswordFile, &cfg.Database.Password},
+		{"redis.password_file", cfg.Redis.PasswordFile, &cfg.Redis.Password},
+		{"redis.sentinel_password_file", cfg.Redis.SentinelPasswordFile, &cfg.Redis.SentinelPassword},
+		{"jwt.secret_file", cfg.JWT.SecretFile, &cfg.JWT.Secret},
+	}
+	for _, s := range secrets {
+		if s.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.name, err)
+		}
+		*s.value = strings.TrimRight(string(data), "\r\n")
+	}
+
+	// The key file lists one id:key per line
+	if file := cfg.TwoFactor.EncryptionKeysFile; file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("two_factor.encryption_keys_file: %v", err)
+		}
+		cfg.TwoFactor.EncryptionKeys = strings.Fields(string(data))
+	}
+	return nil
+}
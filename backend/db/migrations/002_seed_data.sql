@@ -101,6 +101,13 @@ DECLARE
     i INTEGER;
     name_style INTEGER;
 BEGIN
+    -- Only the old runner, which had no schema_migrations, generated accounts
+    -- here. Databases built by the versioned runner get development and test
+    -- data from the seed command instead.
+    IF to_regclass('schema_migrations') IS NOT NULL THEN
+        RETURN;
+    END IF;
+
     FOR i IN 1..50000 LOOP
         LOOP
             -- Generate username using different styles
@@ -0,0 +1,5 @@
+-- The unique index on scores.char_id stays: the score upsert needs it
+DROP TRIGGER IF EXISTS score_events_no_truncate ON score_events;
+DROP TRIGGER IF EXISTS score_events_append_only ON score_events;
+DROP TABLE IF EXISTS score_events;
+DROP FUNCTION IF EXISTS reject_score_event_change();
@@ -0,0 +1,55 @@
+-- Scores are upserted on char_id, which needs at most one scores row per
+-- character. Keep only the most recently written row of any duplicates.
+DELETE FROM scores s
+USING (
+    SELECT score_id,
+           ROW_NUMBER() OVER (
+               PARTITION BY char_id
+               ORDER BY updated_at DESC NULLS LAST, score_id DESC
+           ) AS rn
+    FROM scores
+    WHERE char_id IS NOT NULL
+) d
+WHERE s.score_id = d.score_id AND d.rn > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS scores_char_id_key ON scores(char_id);
+
+-- Create append-only ledger of every score submission
+CREATE TABLE IF NOT EXISTS score_events (
+    event_id BIGSERIAL PRIMARY KEY,
+    char_id INTEGER NOT NULL REFERENCES characters(char_id),
+    reward_score INTEGER NOT NULL,
+    delta INTEGER NOT NULL,
+    source VARCHAR(50) NOT NULL DEFAULT 'api',
+    match_id VARCHAR(100),
+    match_metadata JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_score_events_char_created ON score_events(char_id, created_at DESC, event_id DESC);
+
+-- Ledger rows are never rewritten or removed; corrections are recorded as
+-- new events. A deliberate cleanup has to disable these triggers for the
+-- statement that needs it.
+CREATE OR REPLACE FUNCTION reject_score_event_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'score_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS score_events_append_only ON score_events;
+CREATE TRIGGER score_events_append_only
+    BEFORE UPDATE OR DELETE ON score_events
+    FOR EACH ROW EXECUTE FUNCTION reject_score_event_change();
+
+DROP TRIGGER IF EXISTS score_events_no_truncate ON score_events;
+CREATE TRIGGER score_events_no_truncate
+    BEFORE TRUNCATE ON score_events
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_score_event_change();
+
+-- Backfill one opening event for scores recorded before the ledger existed
+INSERT INTO score_events (char_id, reward_score, delta, source, created_at)
+SELECT s.char_id, s.reward_score, s.reward_score, 'backfill', COALESCE(s.updated_at, s.created_at, CURRENT_TIMESTAMP)
+FROM scores s
+WHERE s.char_id IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM score_events e WHERE e.char_id = s.char_id);
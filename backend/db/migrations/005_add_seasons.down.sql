@@ -0,0 +1,10 @@
+-- Archived standings and season assignments are lost
+DROP TRIGGER IF EXISTS season_standings_immutable ON season_standings;
+DROP TABLE IF EXISTS season_standings;
+DROP FUNCTION IF EXISTS reject_season_standings_change();
+
+DROP INDEX IF EXISTS idx_scores_season_id;
+ALTER TABLE score_events DROP COLUMN IF EXISTS season_id;
+ALTER TABLE scores DROP COLUMN IF EXISTS season_id;
+
+DROP TABLE IF EXISTS seasons;
@@ -0,0 +1,54 @@
+-- Create seasons table; exactly one season is active at a time
+CREATE TABLE IF NOT EXISTS seasons (
+    season_id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'archived')),
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    archived_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_seasons_single_active ON seasons(status) WHERE status = 'active';
+
+INSERT INTO seasons (name)
+SELECT 'Season 1'
+WHERE NOT EXISTS (SELECT 1 FROM seasons);
+
+-- Tie live scores and ledger entries to the season they were earned in
+ALTER TABLE scores ADD COLUMN IF NOT EXISTS season_id INTEGER REFERENCES seasons(season_id);
+UPDATE scores SET season_id = (SELECT season_id FROM seasons WHERE status = 'active') WHERE season_id IS NULL;
+
+ALTER TABLE score_events ADD COLUMN IF NOT EXISTS season_id INTEGER REFERENCES seasons(season_id);
+ALTER TABLE score_events DISABLE TRIGGER score_events_append_only;
+UPDATE score_events SET season_id = (SELECT season_id FROM seasons WHERE status = 'active') WHERE season_id IS NULL;
+ALTER TABLE score_events ENABLE TRIGGER score_events_append_only;
+
+CREATE INDEX IF NOT EXISTS idx_scores_season_id ON scores(season_id);
+
+-- Final standings frozen at rollover
+CREATE TABLE IF NOT EXISTS season_standings (
+    season_id INTEGER NOT NULL REFERENCES seasons(season_id),
+    char_id INTEGER NOT NULL,
+    class_id INTEGER NOT NULL,
+    rank INTEGER NOT NULL,
+    class_rank INTEGER NOT NULL,
+    username VARCHAR(50) NOT NULL,
+    class_name VARCHAR(50) NOT NULL,
+    reward_score INTEGER NOT NULL,
+    PRIMARY KEY (season_id, char_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_season_standings_rank ON season_standings(season_id, rank);
+CREATE INDEX IF NOT EXISTS idx_season_standings_class_rank ON season_standings(season_id, class_id, class_rank);
+
+CREATE OR REPLACE FUNCTION reject_season_standings_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'season_standings is immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS season_standings_immutable ON season_standings;
+CREATE TRIGGER season_standings_immutable
+    BEFORE UPDATE OR DELETE ON season_standings
+    FOR EACH ROW EXECUTE FUNCTION reject_season_standings_change();
@@ -0,0 +1,9 @@
+-- Add account roles; admins can manage seasons
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'player';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'accounts_role_check') THEN
+        ALTER TABLE accounts ADD CONSTRAINT accounts_role_check CHECK (role IN ('player', 'admin'));
+    END IF;
+END $$;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_scores_reward_score_char_id;
+DROP INDEX IF EXISTS idx_season_standings_score_char;
@@ -0,0 +1,3 @@
+-- Support keyset pagination over (reward_score DESC, char_id)
+CREATE INDEX IF NOT EXISTS idx_scores_reward_score_char_id ON scores(reward_score DESC, char_id);
+CREATE INDEX IF NOT EXISTS idx_season_standings_score_char ON season_standings(season_id, reward_score DESC, char_id);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_season_standings_rank_order;
+CREATE INDEX IF NOT EXISTS idx_season_standings_score_char ON season_standings(season_id, reward_score DESC, char_id);
+
+DROP INDEX IF EXISTS idx_scores_rank_order;
+CREATE INDEX IF NOT EXISTS idx_scores_reward_score_char_id ON scores(reward_score DESC, char_id);
+
+ALTER TABLE season_standings DROP COLUMN IF EXISTS achieved_at;
+ALTER TABLE scores DROP COLUMN IF EXISTS achieved_at;
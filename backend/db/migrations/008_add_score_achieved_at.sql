@@ -0,0 +1,15 @@
+-- Record when each character reached its current score; the earliest to
+-- reach a score ranks first among ties
+ALTER TABLE scores ADD COLUMN IF NOT EXISTS achieved_at TIMESTAMP WITH TIME ZONE;
+UPDATE scores SET achieved_at = COALESCE(updated_at, created_at, CURRENT_TIMESTAMP) WHERE achieved_at IS NULL;
+ALTER TABLE scores ALTER COLUMN achieved_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE scores ALTER COLUMN achieved_at SET NOT NULL;
+
+ALTER TABLE season_standings ADD COLUMN IF NOT EXISTS achieved_at TIMESTAMP WITH TIME ZONE;
+
+-- Keyset pagination now orders by (reward_score DESC, achieved_at, char_id)
+DROP INDEX IF EXISTS idx_scores_reward_score_char_id;
+CREATE INDEX IF NOT EXISTS idx_scores_rank_order ON scores(reward_score DESC, achieved_at, char_id);
+
+DROP INDEX IF EXISTS idx_season_standings_score_char;
+CREATE INDEX IF NOT EXISTS idx_season_standings_rank_order ON season_standings(season_id, reward_score DESC, achieved_at, char_id);
@@ -0,0 +1,3 @@
+-- pg_trgm is left installed; other objects may depend on it
+DROP INDEX IF EXISTS idx_accounts_username_trgm;
+DROP INDEX IF EXISTS idx_accounts_created_at;
@@ -0,0 +1,5 @@
+-- Trigram index for player search by username (prefix, substring and fuzzy)
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_accounts_username_trgm ON accounts USING GIN (LOWER(username) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_accounts_created_at ON accounts(created_at);
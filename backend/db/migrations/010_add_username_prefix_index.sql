@@ -0,0 +1,4 @@
+-- Prefix index for username autocomplete. The C collation lets LIKE 'abc%'
+-- use the index whatever the database collation is, and also serves
+-- ORDER BY ... COLLATE "C" so a lookup stops after the first few matches.
+CREATE INDEX IF NOT EXISTS idx_accounts_username_prefix ON accounts ((LOWER(username) COLLATE "C"));
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS audit_log;
+
+ALTER TABLE score_events DROP COLUMN IF EXISTS submitted_by;
+
+-- Fails while game_server accounts exist; remove or reassign them first
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_role_check;
+ALTER TABLE accounts ADD CONSTRAINT accounts_role_check CHECK (role IN ('player', 'admin'));
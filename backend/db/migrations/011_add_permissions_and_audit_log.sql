@@ -0,0 +1,31 @@
+-- Game servers submit scores under their own principal
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname = 'accounts_role_check'
+          AND pg_get_constraintdef(oid) LIKE '%game_server%'
+    ) THEN
+        ALTER TABLE accounts DROP CONSTRAINT IF EXISTS accounts_role_check;
+        ALTER TABLE accounts ADD CONSTRAINT accounts_role_check CHECK (role IN ('player', 'game_server', 'admin'));
+    END IF;
+END $$;
+
+-- Record who submitted each score
+ALTER TABLE score_events ADD COLUMN IF NOT EXISTS submitted_by INTEGER REFERENCES accounts(acc_id);
+
+-- Security-relevant events such as rejected score submissions
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id BIGSERIAL PRIMARY KEY,
+    acc_id INTEGER REFERENCES accounts(acc_id) ON DELETE SET NULL,
+    action VARCHAR(100) NOT NULL,
+    resource VARCHAR(255),
+    outcome VARCHAR(20) NOT NULL,
+    reason TEXT,
+    client_ip VARCHAR(64),
+    metadata JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_acc_id ON audit_log(acc_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, created_at);
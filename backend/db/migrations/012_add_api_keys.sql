@@ -0,0 +1,25 @@
+-- API keys identify game servers submitting scores. Only a SHA-256 hash of
+-- the secret is kept; requests are signed with that hash as the HMAC key.
+CREATE TABLE IF NOT EXISTS api_keys (
+    key_id VARCHAR(32) PRIMARY KEY,
+    acc_id INTEGER NOT NULL REFERENCES accounts(acc_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    secret_hash BYTEA NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    rate_limit_per_minute INTEGER NOT NULL DEFAULT 600 CHECK (rate_limit_per_minute > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_acc_id ON api_keys(acc_id);
+
+-- Nonces seen within the signature window, for replay protection
+CREATE TABLE IF NOT EXISTS api_key_nonces (
+    key_id VARCHAR(32) NOT NULL REFERENCES api_keys(key_id) ON DELETE CASCADE,
+    nonce VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (key_id, nonce)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_nonces_created_at ON api_key_nonces(created_at);
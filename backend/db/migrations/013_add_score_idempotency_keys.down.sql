@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_score_events_idempotency;
+ALTER TABLE score_events DROP COLUMN IF EXISTS idempotency_key;
@@ -0,0 +1,7 @@
+-- Idempotency keys let game servers retry score submissions safely. Keys are
+-- scoped to the submitting account.
+ALTER TABLE score_events ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(128);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_score_events_idempotency
+    ON score_events(submitted_by, idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,41 @@
+-- Deleted and clamped rows are not restored
+ALTER TABLE sessions DROP CONSTRAINT IF EXISTS sessions_acc_id_fkey;
+ALTER TABLE sessions ADD CONSTRAINT sessions_acc_id_fkey
+    FOREIGN KEY (acc_id) REFERENCES accounts(acc_id);
+
+-- Events of deleted characters keep their NULL char_id, so the column stays
+-- nullable
+ALTER TABLE score_events DROP CONSTRAINT IF EXISTS score_events_submitted_by_fkey;
+ALTER TABLE score_events ADD CONSTRAINT score_events_submitted_by_fkey
+    FOREIGN KEY (submitted_by) REFERENCES accounts(acc_id);
+
+ALTER TABLE score_events DROP CONSTRAINT IF EXISTS score_events_char_id_fkey;
+ALTER TABLE score_events ADD CONSTRAINT score_events_char_id_fkey
+    FOREIGN KEY (char_id) REFERENCES characters(char_id);
+
+CREATE OR REPLACE FUNCTION reject_score_event_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'score_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_char_id_fkey;
+ALTER TABLE scores ADD CONSTRAINT scores_char_id_fkey
+    FOREIGN KEY (char_id) REFERENCES characters(char_id);
+
+ALTER TABLE characters DROP CONSTRAINT IF EXISTS characters_class_id_fkey;
+ALTER TABLE characters ADD CONSTRAINT characters_class_id_fkey
+    FOREIGN KEY (class_id) REFERENCES classes(id);
+
+ALTER TABLE characters DROP CONSTRAINT IF EXISTS characters_acc_id_fkey;
+ALTER TABLE characters ADD CONSTRAINT characters_acc_id_fkey
+    FOREIGN KEY (acc_id) REFERENCES accounts(acc_id);
+
+ALTER TABLE season_standings DROP CONSTRAINT IF EXISTS season_standings_reward_score_check;
+ALTER TABLE score_events DROP CONSTRAINT IF EXISTS score_events_reward_score_check;
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_reward_score_check;
+
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_char_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS scores_char_id_key ON scores(char_id);
+CREATE INDEX IF NOT EXISTS idx_scores_char_id ON scores(char_id);
+ALTER TABLE scores ALTER COLUMN char_id DROP NOT NULL;
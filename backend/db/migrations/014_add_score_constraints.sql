@@ -0,0 +1,64 @@
+-- scores holds one live row per character; UpdateScore upserts on char_id.
+-- 004 removed duplicate rows and added a unique index; drop rows without a
+-- character and make the index the table's unique constraint.
+DELETE FROM scores WHERE char_id IS NULL;
+
+ALTER TABLE scores ALTER COLUMN char_id SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_scores_char_id;
+ALTER TABLE scores ADD CONSTRAINT scores_char_id_key UNIQUE USING INDEX scores_char_id_key;
+
+-- Scores cannot go below zero. Live scores are clamped; ledger rows are
+-- append-only and standings are frozen, so the checks there apply to new
+-- rows only.
+UPDATE scores SET reward_score = 0 WHERE reward_score < 0;
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_reward_score_check;
+ALTER TABLE scores ADD CONSTRAINT scores_reward_score_check CHECK (reward_score >= 0);
+
+ALTER TABLE score_events DROP CONSTRAINT IF EXISTS score_events_reward_score_check;
+ALTER TABLE score_events ADD CONSTRAINT score_events_reward_score_check CHECK (reward_score >= 0) NOT VALID;
+
+ALTER TABLE season_standings DROP CONSTRAINT IF EXISTS season_standings_reward_score_check;
+ALTER TABLE season_standings ADD CONSTRAINT season_standings_reward_score_check CHECK (reward_score >= 0) NOT VALID;
+
+-- Deleting an account removes its characters, sessions and scores. The
+-- ledger keeps its events for balance history but forgets whose they were:
+-- char_id and submitted_by are set to NULL, the one change the append-only
+-- guard lets through.
+CREATE OR REPLACE FUNCTION reject_score_event_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+       AND (NEW.char_id IS NULL OR NEW.char_id = OLD.char_id)
+       AND (NEW.submitted_by IS NULL OR NEW.submitted_by = OLD.submitted_by)
+       AND to_jsonb(NEW) - 'char_id' - 'submitted_by' = to_jsonb(OLD) - 'char_id' - 'submitted_by'
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'score_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE characters DROP CONSTRAINT IF EXISTS characters_acc_id_fkey;
+ALTER TABLE characters ADD CONSTRAINT characters_acc_id_fkey
+    FOREIGN KEY (acc_id) REFERENCES accounts(acc_id) ON DELETE CASCADE;
+
+ALTER TABLE characters DROP CONSTRAINT IF EXISTS characters_class_id_fkey;
+ALTER TABLE characters ADD CONSTRAINT characters_class_id_fkey
+    FOREIGN KEY (class_id) REFERENCES classes(id) ON DELETE RESTRICT;
+
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_char_id_fkey;
+ALTER TABLE scores ADD CONSTRAINT scores_char_id_fkey
+    FOREIGN KEY (char_id) REFERENCES characters(char_id) ON DELETE CASCADE;
+
+ALTER TABLE score_events ALTER COLUMN char_id DROP NOT NULL;
+ALTER TABLE score_events DROP CONSTRAINT IF EXISTS score_events_char_id_fkey;
+ALTER TABLE score_events ADD CONSTRAINT score_events_char_id_fkey
+    FOREIGN KEY (char_id) REFERENCES characters(char_id) ON DELETE SET NULL;
+
+ALTER TABLE score_events DROP CONSTRAINT IF EXISTS score_events_submitted_by_fkey;
+ALTER TABLE score_events ADD CONSTRAINT score_events_submitted_by_fkey
+    FOREIGN KEY (submitted_by) REFERENCES accounts(acc_id) ON DELETE SET NULL;
+
+ALTER TABLE sessions DROP CONSTRAINT IF EXISTS sessions_acc_id_fkey;
+ALTER TABLE sessions ADD CONSTRAINT sessions_acc_id_fkey
+    FOREIGN KEY (acc_id) REFERENCES accounts(acc_id) ON DELETE CASCADE;
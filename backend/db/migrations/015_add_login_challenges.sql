@@ -0,0 +1,13 @@
+-- A login challenge is issued once the password has been verified for an
+-- account with 2FA, and is answered with a code. Each challenge can be used
+-- once and allows a limited number of attempts.
+CREATE TABLE IF NOT EXISTS login_challenges (
+    challenge_id VARCHAR(64) PRIMARY KEY,
+    acc_id INTEGER NOT NULL REFERENCES accounts(acc_id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_challenges_expires_at ON login_challenges(expires_at);
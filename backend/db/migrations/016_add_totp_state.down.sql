@@ -0,0 +1,5 @@
+ALTER TABLE accounts DROP COLUMN IF EXISTS two_factor_locked_until;
+ALTER TABLE accounts DROP COLUMN IF EXISTS two_factor_failures;
+ALTER TABLE accounts DROP COLUMN IF EXISTS two_factor_last_step;
+ALTER TABLE accounts DROP COLUMN IF EXISTS two_factor_digits;
+ALTER TABLE accounts DROP COLUMN IF EXISTS two_factor_algorithm;
@@ -0,0 +1,8 @@
+-- TOTP parameters are fixed at enrollment, so changing the configured
+-- defaults does not break existing authenticators. The last used time step
+-- stops a code being replayed; failures drive the login lockout.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS two_factor_algorithm VARCHAR(10) NOT NULL DEFAULT 'SHA1';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS two_factor_digits SMALLINT NOT NULL DEFAULT 6;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS two_factor_last_step BIGINT;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS two_factor_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS two_factor_locked_until TIMESTAMP WITH TIME ZONE;
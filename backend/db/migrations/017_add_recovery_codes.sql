@@ -0,0 +1,10 @@
+-- One-time codes for logging in without the authenticator. Only a SHA-256
+-- hash of each code is kept; used codes stay for the record.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    code_id SERIAL PRIMARY KEY,
+    acc_id INTEGER NOT NULL REFERENCES accounts(acc_id) ON DELETE CASCADE,
+    code_hash BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (acc_id, code_hash)
+);
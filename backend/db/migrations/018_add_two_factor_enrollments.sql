@@ -0,0 +1,14 @@
+-- A 2FA enrollment waiting to be confirmed with a code. The secret is
+-- generated and kept by the server; an account has at most one pending
+-- enrollment, replaced whenever enrollment starts again.
+CREATE TABLE IF NOT EXISTS two_factor_enrollments (
+    acc_id INTEGER PRIMARY KEY REFERENCES accounts(acc_id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    algorithm VARCHAR(10) NOT NULL,
+    digits SMALLINT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_two_factor_enrollments_expires_at ON two_factor_enrollments(expires_at);
@@ -0,0 +1,13 @@
+-- TOTP secrets are stored sealed with the key ring (see auth/keyring.go),
+-- next to the ID of the ring key that sealed them. The Go step registered
+-- for this migration seals the existing plaintext secrets; 020 then drops
+-- the plaintext column.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS two_factor_secret_sealed BYTEA;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS two_factor_key_id VARCHAR(64);
+
+-- Pending enrollments last minutes, so they are dropped rather than
+-- migrated; users restart enrollment.
+DELETE FROM two_factor_enrollments;
+ALTER TABLE two_factor_enrollments DROP COLUMN secret;
+ALTER TABLE two_factor_enrollments ADD COLUMN secret_sealed BYTEA NOT NULL;
+ALTER TABLE two_factor_enrollments ADD COLUMN key_id VARCHAR(64) NOT NULL;
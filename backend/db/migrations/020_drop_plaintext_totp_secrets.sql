@@ -0,0 +1,7 @@
+-- 019 sealed every secret, so the plaintext column is no longer read.
+-- Neither migration can be reverted: the sealed secrets cannot be decrypted
+-- in SQL.
+ALTER TABLE accounts DROP COLUMN two_factor_secret;
+
+ALTER TABLE accounts ADD CONSTRAINT accounts_two_factor_key_check
+    CHECK ((two_factor_secret_sealed IS NULL) = (two_factor_key_id IS NULL));
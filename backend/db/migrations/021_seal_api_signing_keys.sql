@@ -0,0 +1,8 @@
+-- secret_hash held SHA-256 of the secret, which is also the key requests are
+-- signed with, so reading api_keys was enough to forge signed requests. The
+-- signing key is now stored sealed with the key ring (see auth/keyring.go),
+-- and secret_hash becomes SHA-256 of the signing key, which cannot sign. The
+-- Go step registered for this migration converts the existing keys; it
+-- cannot be reverted.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_key_sealed BYTEA;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_key_id VARCHAR(64);
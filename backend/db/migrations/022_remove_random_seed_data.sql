@@ -0,0 +1,30 @@
+-- The old runner ran 002 on every start, and each run added 50,000 random
+-- accounts with characters and scores; 002 now skips that on databases built
+-- by the versioned runner, which find nothing to remove here. A run inserted
+-- its accounts in a single transaction, so they all carry that transaction's
+-- timestamp as created_at. That timestamp tags them: registration creates
+-- one account per transaction and the seed command spreads created_at out,
+-- so no other 50,000 accounts share one.
+CREATE TEMPORARY TABLE random_seed_accounts ON COMMIT DROP AS
+SELECT acc_id
+FROM accounts
+WHERE created_at IN (
+    SELECT created_at
+    FROM accounts
+    WHERE created_at IS NOT NULL
+    GROUP BY created_at
+    HAVING COUNT(*) = 50000
+);
+
+-- The ledger keeps the history of real characters; these were never played,
+-- so its append-only guard is lifted for this one delete
+ALTER TABLE score_events DISABLE TRIGGER score_events_append_only;
+DELETE FROM score_events e
+USING characters c, random_seed_accounts r
+WHERE e.char_id = c.char_id AND c.acc_id = r.acc_id;
+ALTER TABLE score_events ENABLE TRIGGER score_events_append_only;
+
+-- Characters, scores and sessions go with their accounts
+DELETE FROM accounts WHERE acc_id IN (SELECT acc_id FROM random_seed_accounts);
+
+DROP FUNCTION IF EXISTS random_string(integer);
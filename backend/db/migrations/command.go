@@ -0,0 +1,100 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Usage describes the commands accepted by Command.
+const Usage = `commands:
+  up                 apply all pending migrations
+  down [n]           revert the last n migrations (default 1); refused,
+                     reverting nothing, if any of them has no down file
+  redo               revert and reapply the last migration
+  status             list migrations and their state
+  check              fail if migrations are pending or have drifted
+  baseline <version> record migrations up to version as applied without
+                     running them, for databases built before versioning
+  reset              drop every table and apply all migrations; for
+                     development databases only`
+
+// Command runs one migration command, writing its report to w.
+func Command(ctx context.Context, db *sql.DB, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", Usage)
+	}
+	m, err := New(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		fmt.Fprintf(w, "%d migrations applied\n", n)
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count: %s", args[1])
+			}
+		}
+		n, err := m.Down(ctx, steps)
+		fmt.Fprintf(w, "%d migrations reverted\n", n)
+		return err
+
+	case "redo":
+		return m.Redo(ctx)
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "-"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(tw, "%03d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, appliedAt)
+		}
+		return tw.Flush()
+
+	case "check":
+		if err := m.Check(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "schema is up to date")
+		return nil
+
+	case "reset":
+		if _, err := db.ExecContext(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public;"); err != nil {
+			return fmt.Errorf("error resetting database: %v", err)
+		}
+		n, err := m.Up(ctx)
+		fmt.Fprintf(w, "database reset, %d migrations applied\n", n)
+		return err
+
+	case "baseline":
+		if len(args) < 2 {
+			return fmt.Errorf("baseline needs a version")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", args[1])
+		}
+		n, err := m.Baseline(ctx, version)
+		fmt.Fprintf(w, "%d migrations recorded as applied\n", n)
+		return err
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], Usage)
+}
@@ -0,0 +1,427 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lockKey is the Postgres advisory lock held while migrating, so instances
+// that start together apply each migration once.
+const lockKey = 7262001800
+
+// Migration states reported by Status
+const (
+	StateApplied = "applied"
+	StatePending = "pending"
+	// StateDrifted means the embedded file no longer matches the checksum
+	// recorded when it was applied
+	StateDrifted = "drifted"
+	// StateMissing means the database records a version this build does not
+	// know about
+	StateMissing = "missing"
+)
+
+var (
+	ErrDrift = errors.New("applied migrations do not match this build")
+	// ErrUnversioned means the schema was created before schema_migrations
+	// existed and must be baselined before migrating.
+	ErrUnversioned = errors.New("database has tables but no recorded migrations; run baseline first")
+	ErrNoDown      = errors.New("migration has no down file and cannot be reverted")
+)
+
+// Status describes one migration known to the build, the database or both.
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+type appliedRow struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Step is Go code run after a migration's SQL, in the same transaction, for
+// changes SQL cannot make on its own.
+type Step func(ctx context.Context, tx *sql.Tx) error
+
+// needsStep lists the migrations that cannot be applied without their Step,
+// and what the step does.
+var needsStep = map[int64]string{
+	19: "encrypt existing TOTP secrets with the configured key ring",
+	21: "encrypt existing API signing keys with the configured key ring",
+}
+
+var steps = make(map[int64]Step)
+
+// RegisterStep sets the Go step run with a migration. It must be called
+// before migrating.
+func RegisterStep(version int64, step Step) {
+	steps[version] = step
+}
+
+// Migrator applies the embedded migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// withLock runs fn on one connection holding the migration lock. Advisory
+// locks belong to a session, so everything has to share that connection.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("error taking migration lock: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]appliedRow, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedRow)
+	for rows.Next() {
+		var version int64
+		var row appliedRow
+		if err := rows.Scan(&version, &row.name, &row.checksum, &row.appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning schema_migrations: %v", err)
+		}
+		applied[version] = row
+	}
+	return applied, rows.Err()
+}
+
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]Status, error) {
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	known := make(map[int64]bool)
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		s := Status{Version: mig.Version, Name: mig.Name, State: StatePending}
+		if row, ok := applied[mig.Version]; ok {
+			s.State = StateApplied
+			if row.checksum != mig.Checksum {
+				s.State = StateDrifted
+			}
+			appliedAt := row.appliedAt
+			s.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, s)
+	}
+	for version, row := range applied {
+		if !known[version] {
+			appliedAt := row.appliedAt
+			statuses = append(statuses, Status{Version: version, Name: row.name, State: StateMissing, AppliedAt: &appliedAt})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Status reports every migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		statuses, err = m.status(ctx, conn)
+		return err
+	})
+	return statuses, err
+}
+
+// driftError lists drifted and missing migrations, or returns nil.
+func driftError(statuses []Status) error {
+	var bad []string
+	for _, s := range statuses {
+		if s.State == StateDrifted || s.State == StateMissing {
+			bad = append(bad, fmt.Sprintf("%d_%s (%s)", s.Version, s.Name, s.State))
+		}
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrDrift, strings.Join(bad, ", "))
+}
+
+// Check reports drift, or pending migrations when there is no drift.
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if err := driftError(statuses); err != nil {
+		return err
+	}
+	pending := 0
+	for _, s := range statuses {
+		if s.State == StatePending {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d pending migrations", pending)
+	}
+	return nil
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction, and returns how many were applied. Nothing is applied while
+// any applied migration has drifted.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := driftError(statuses); err != nil {
+			return err
+		}
+		if err := m.checkVersioned(ctx, conn, statuses); err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if stateOf(statuses, mig.Version) != StatePending {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// checkVersioned refuses to migrate a schema built by the old runner, which
+// would re-run migrations that are already in place.
+func (m *Migrator) checkVersioned(ctx context.Context, conn *sql.Conn, statuses []Status) error {
+	for _, s := range statuses {
+		if s.State != StatePending {
+			return nil
+		}
+	}
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass('public.accounts') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrUnversioned
+	}
+	return nil
+}
+
+func stateOf(statuses []Status, version int64) string {
+	for _, s := range statuses {
+		if s.Version == version {
+			return s.State
+		}
+	}
+	return ""
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	step := steps[mig.Version]
+	if what, ok := needsStep[mig.Version]; ok && step == nil {
+		return fmt.Errorf("migration %d_%s needs a step to %s, which was not registered", mig.Version, mig.Name, what)
+	}
+
+	start := time.Now()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+		return fmt.Errorf("error applying migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+	if step != nil {
+		if err := step(ctx, tx); err != nil {
+			return fmt.Errorf("error applying migration %d_%s: %v", mig.Version, mig.Name, err)
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+		mig.Version, mig.Name, mig.Checksum)
+	if err != nil {
+		return fmt.Errorf("error recording migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Applied migration %d_%s in %s", mig.Version, mig.Name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	if mig.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrNoDown, mig.Version, mig.Name)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+		return fmt.Errorf("error reverting migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", mig.Version); err != nil {
+		return fmt.Errorf("error recording migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Reverted migration %d_%s", mig.Version, mig.Name)
+	return nil
+}
+
+// newestApplied returns the newest n applied migrations, newest first, or
+// fewer if fewer are applied. Reverting one this build does not know, or
+// whose file has changed, is refused, as is reverting past a migration with
+// no down file; nothing is reverted in either case.
+func (m *Migrator) newestApplied(statuses []Status, n int) ([]Migration, error) {
+	var migs []Migration
+	for i := len(statuses) - 1; i >= 0 && len(migs) < n; i-- {
+		s := statuses[i]
+		if s.State == StatePending {
+			continue
+		}
+		mig, ok := m.find(s.Version)
+		if s.State != StateApplied || !ok {
+			return nil, fmt.Errorf("%w: %d_%s (%s)", ErrDrift, s.Version, s.Name, s.State)
+		}
+		if mig.Down == "" {
+			return nil, fmt.Errorf("%w: %d_%s; nothing was reverted", ErrNoDown, mig.Version, mig.Name)
+		}
+		migs = append(migs, mig)
+	}
+	return migs, nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+// Down reverts the newest steps applied migrations and returns how many
+// were reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		migs, err := m.newestApplied(statuses, steps)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migs {
+			if err := m.revert(ctx, conn, mig); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Redo reverts and reapplies the newest applied migration.
+func (m *Migrator) Redo(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		migs, err := m.newestApplied(statuses, 1)
+		if err != nil {
+			return err
+		}
+		if len(migs) == 0 {
+			return fmt.Errorf("no applied migration to redo")
+		}
+		if err := m.revert(ctx, conn, migs[0]); err != nil {
+			return err
+		}
+		return m.apply(ctx, conn, migs[0])
+	})
+}
+
+// Baseline records every migration up to version as applied without running
+// it, for databases built by the old runner.
+func (m *Migrator) Baseline(ctx context.Context, version int64) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+			res, err := conn.ExecContext(ctx, `
+				INSERT INTO schema_migrations (version, name, checksum)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (version) DO NOTHING
+			`, mig.Version, mig.Name, mig.Checksum)
+			if err != nil {
+				return fmt.Errorf("error recording migration %d_%s: %v", mig.Version, mig.Name, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,261 @@
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Command parses seed flags from args and loads the dataset they describe,
+// writing progress to w.
+func Command(ctx context.Context, db *sql.DB, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.SetOutput(w)
+	accounts := flags.Int("accounts", 1000, "number of accounts")
+	characters := flags.String("characters", "1-3", "characters per account, N or MIN-MAX")
+	dist := flags.String("distribution", DistUniform, "score distribution: uniform, normal or power-law")
+	maxScore := flags.Int("max-score", 10000, "highest possible score")
+	alpha := flags.Float64("alpha", 1.5, "power-law exponent")
+	seed := flags.Int64("seed", 1, "random seed; the same seed and flags give the same data")
+	classMix := flags.String("class-mix", "", "class weights, e.g. PAHLAWAN=3,VAIDYA=1 (default: all classes equally)")
+	truncate := flags.Bool("truncate", false, "delete existing accounts, characters and scores first")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := Options{
+		Accounts:     *accounts,
+		Distribution: *dist,
+		MaxScore:     *maxScore,
+		Alpha:        *alpha,
+		Seed:         *seed,
+	}
+	var err error
+	opts.MinCharacters, opts.MaxCharacters, err = parseRange(*characters)
+	if err != nil {
+		return err
+	}
+	opts.ClassWeights, err = classWeights(ctx, db, *classMix)
+	if err != nil {
+		return err
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	stats, err := Load(ctx, db, opts, *truncate)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Seeded %d accounts and %d characters in %s\n",
+		stats.Accounts, stats.Characters, time.Since(start).Round(time.Millisecond))
+	fmt.Fprintln(w, "Rebuild the leaderboard (POST /api/leaderboard/rebuild) or restart the server to serve the new scores")
+	return nil
+}
+
+func parseRange(s string) (int, int, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	min, err := strconv.Atoi(lo)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid characters per account: %s", s)
+	}
+	if !isRange {
+		return min, min, nil
+	}
+	max, err := strconv.Atoi(hi)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid characters per account: %s", s)
+	}
+	return min, max, nil
+}
+
+// classWeights resolves a NAME=WEIGHT list against the classes table. An
+// empty mix weights every class equally.
+func classWeights(ctx context.Context, db *sql.DB, mix string) (map[int]float64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, name FROM classes")
+	if err != nil {
+		return nil, fmt.Errorf("error querying classes: %v", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("error scanning class: %v", err)
+		}
+		ids[strings.ToUpper(name)] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no classes found; run the migrations first")
+	}
+
+	weights := make(map[int]float64)
+	if mix == "" {
+		for _, id := range ids {
+			weights[id] = 1
+		}
+		return weights, nil
+	}
+	for _, part := range strings.Split(mix, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		id, known := ids[strings.ToUpper(name)]
+		if !ok || !known {
+			return nil, fmt.Errorf("invalid class mix entry %q", part)
+		}
+		w, err := strconv.ParseFloat(weight, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid class mix entry %q", part)
+		}
+		weights[id] = w
+	}
+	return weights, nil
+}
+
+// Stats counts the rows loaded.
+type Stats struct {
+	Accounts   int
+	Characters int
+}
+
+// Load generates the dataset for opts and bulk loads it with COPY in one
+// transaction. The account and character tables must be empty unless
+// truncate is set, since rows are loaded with fixed IDs.
+func Load(ctx context.Context, db *sql.DB, opts Options, truncate bool) (Stats, error) {
+	var stats Stats
+	gen := NewGenerator(opts)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	if truncate {
+		// Cascades to sessions, API keys and everything else tied to accounts.
+		// The ledger refuses truncation, so its guard is lifted for this
+		// statement only; ALTER TABLE is part of the transaction.
+		_, err = tx.ExecContext(ctx, `
+			ALTER TABLE score_events DISABLE TRIGGER score_events_no_truncate;
+			TRUNCATE accounts, characters, scores, score_events RESTART IDENTITY CASCADE;
+			ALTER TABLE score_events ENABLE TRIGGER score_events_no_truncate;
+		`)
+		if err != nil {
+			return stats, fmt.Errorf("error truncating tables: %v", err)
+		}
+	} else {
+		var exists bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM accounts) OR EXISTS (SELECT 1 FROM characters)").Scan(&exists)
+		if err != nil {
+			return stats, err
+		}
+		if exists {
+			return stats, fmt.Errorf("accounts or characters already has rows; pass -truncate to replace them")
+		}
+	}
+
+	var seasonID int
+	if err := tx.QueryRowContext(ctx, "SELECT season_id FROM seasons WHERE status = 'active'").Scan(&seasonID); err != nil {
+		return stats, fmt.Errorf("error finding active season: %v", err)
+	}
+
+	// COPY streams one table at a time, so the dataset is generated once per
+	// table; generation is deterministic and cheap next to the load itself
+	tables := []struct {
+		name    string
+		columns []string
+		rows    func(a *Account, row func(values ...interface{}) error) error
+	}{
+		{"accounts", []string{"acc_id", "username", "email", "password_hash", "created_at", "updated_at"},
+			func(a *Account, row func(...interface{}) error) error {
+				return row(a.ID, a.Username, a.Email, PasswordHash, a.CreatedAt, a.CreatedAt)
+			}},
+		{"characters", []string{"char_id", "acc_id", "class_id", "created_at", "updated_at"},
+			func(a *Account, row func(...interface{}) error) error {
+				for _, c := range a.Characters {
+					if err := row(c.ID, a.ID, c.ClassID, a.CreatedAt, a.CreatedAt); err != nil {
+						return err
+					}
+				}
+				return nil
+			}},
+		{"scores", []string{"char_id", "reward_score", "season_id", "achieved_at", "created_at", "updated_at"},
+			func(a *Account, row func(...interface{}) error) error {
+				for _, c := range a.Characters {
+					if err := row(c.ID, c.Score, seasonID, c.AchievedAt, c.AchievedAt, c.AchievedAt); err != nil {
+						return err
+					}
+				}
+				return nil
+			}},
+		{"score_events", []string{"char_id", "reward_score", "delta", "source", "season_id", "created_at"},
+			func(a *Account, row func(...interface{}) error) error {
+				for _, c := range a.Characters {
+					if err := row(c.ID, c.Score, c.Score, "backfill", seasonID, c.AchievedAt); err != nil {
+						return err
+					}
+				}
+				return nil
+			}},
+	}
+
+	for _, t := range tables {
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn(t.name, t.columns...))
+		if err != nil {
+			return stats, fmt.Errorf("error starting copy into %s: %v", t.name, err)
+		}
+		row := func(values ...interface{}) error {
+			_, err := stmt.ExecContext(ctx, values...)
+			return err
+		}
+		stats = Stats{}
+		err = gen.Each(func(a *Account) error {
+			stats.Accounts++
+			stats.Characters += len(a.Characters)
+			return t.rows(a, row)
+		})
+		if err == nil {
+			_, err = stmt.ExecContext(ctx)
+		}
+		if err != nil {
+			stmt.Close()
+			return stats, fmt.Errorf("error copying into %s: %v", t.name, err)
+		}
+		if err := stmt.Close(); err != nil {
+			return stats, err
+		}
+	}
+
+	// Rows were loaded with explicit IDs; move the sequences past them
+	for _, q := range []string{
+		"SELECT setval(pg_get_serial_sequence('accounts', 'acc_id'), GREATEST(MAX(acc_id), 1)) FROM accounts",
+		"SELECT setval(pg_get_serial_sequence('characters', 'char_id'), GREATEST(MAX(char_id), 1)) FROM characters",
+	} {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return stats, fmt.Errorf("error resetting sequences: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return stats, err
+	}
+
+	for _, table := range []string{"accounts", "characters", "scores", "score_events"} {
+		if _, err := db.ExecContext(ctx, "ANALYZE "+table); err != nil {
+			return stats, fmt.Errorf("error analyzing %s: %v", table, err)
+		}
+	}
+	return stats, nil
+}
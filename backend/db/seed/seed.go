@@ -0,0 +1,252 @@
+// Package seed generates reproducible accounts, characters and scores for
+// development and load testing. The same Options always produce the same
+// rows, whatever the dataset size: every account is generated from its own
+// random stream derived from the seed and its index.
+package seed
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Score distributions
+const (
+	DistUniform  = "uniform"
+	DistNormal   = "normal"
+	DistPowerLaw = "power-law"
+)
+
+// PasswordHash is the bcrypt hash of "password123", shared by every seeded
+// account.
+const PasswordHash = "$2a$10$3QxDjD1ylgPnRgQLhBrTaeqdsNaLxkk7gpdsFGUjaP/.PeqE6nqwa"
+
+// epoch anchors generated timestamps so they do not depend on when the
+// seed runs.
+var epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Options describes a dataset.
+type Options struct {
+	Accounts      int
+	MinCharacters int
+	MaxCharacters int
+	Distribution  string
+	MaxScore      int
+	// Alpha is the exponent of the power-law distribution; smaller values
+	// give a longer tail of high scores
+	Alpha float64
+	Seed  int64
+	// ClassWeights maps class IDs to their relative share of characters
+	ClassWeights map[int]float64
+}
+
+// Account is one generated account with its characters.
+type Account struct {
+	ID         int
+	Username   string
+	Email      string
+	CreatedAt  time.Time
+	Characters []Character
+}
+
+// Character is one generated character and its score.
+type Character struct {
+	ID         int
+	ClassID    int
+	Score      int
+	AchievedAt time.Time
+}
+
+var (
+	prefixes = []string{
+		"Hang", "Puteri", "Putera", "Tun", "Datuk", "Sri", "Raja", "Panglima", "Laksamana", "Bendahara",
+		"Harimau", "Kenyalang", "Rajawali", "Cempaka", "Bunga", "Tanjung", "Melati", "Bayu", "Langit",
+		"Meranti", "Rimba", "Batik", "Keris", "Teratai", "Sakti", "Badang", "Gunung", "Kencana",
+		"Gemilang", "Perwira", "Pahlawan", "Pendekar", "Gagah", "Jebat", "Tuah", "Angkasa", "Taufan",
+		"Pelangi", "Dewata", "Timur", "Sejati", "Perkasa", "Merah", "Biru", "Kinabalu", "Langkawi",
+		"Borneo", "Sinar", "Mahkota", "Cahaya", "Samudera", "Serumpun", "Sultan", "Tunku", "Tok",
+	}
+	suffixes = []string{
+		"Pahlawan", "Pendekar", "Wira", "Jaguh", "Perkasa", "Harimau", "Rajawali", "Satria", "Helang",
+		"Penyelamat", "Langit", "Gunung", "Gemilang", "Terbilang", "Taufan", "Bayu", "Pelangi",
+		"Semangat", "Tuah", "Jebat", "Lekiu", "Lekir", "Dewata", "Awan", "Bentara", "Duta", "Panglima",
+		"Perwira", "Pendita", "Harmoni", "Bersinar", "Bersatu", "Jiwa", "Mahkota", "Sakti", "Damai",
+		"Cendana", "Samudera", "Adiwira", "Bumi", "Rimba", "Cahaya", "Sinar", "Agung", "Indah", "Gelora",
+	}
+	names = []string{
+		"Adam", "Aiman", "Farah", "Amira", "Afiq", "Aina", "Hafiz", "Hakim", "Nadia", "Azizah",
+		"Tuah", "Jebat", "Lekiu", "Mahsuri", "Melur", "Seri", "Badang", "Budi", "Sufi", "Taufik",
+		"Iskandar", "Syafiq", "Alia", "Siti", "Nurul", "Zahid", "Aisyah", "Rahim", "Salmah", "Ali",
+		"Fatimah", "Imran", "Azhar", "Rania", "Ridwan", "Shahrul", "Latiff", "Zainal", "Hilmi", "Rosli",
+		"Mazlan", "Zarina", "Arif", "Hamzah", "Ahmad", "Hanif", "Kamal", "Hidayah", "Faizal", "Yasmin",
+	}
+)
+
+// Validate checks that o describes a dataset that can be generated.
+func (o Options) Validate() error {
+	if o.Accounts < 1 {
+		return fmt.Errorf("accounts must be at least 1")
+	}
+	if o.MinCharacters < 0 || o.MaxCharacters < o.MinCharacters {
+		return fmt.Errorf("invalid characters per account range %d-%d", o.MinCharacters, o.MaxCharacters)
+	}
+	if o.MaxScore < 1 {
+		return fmt.Errorf("max score must be positive")
+	}
+	switch o.Distribution {
+	case DistUniform, DistNormal:
+	case DistPowerLaw:
+		if o.Alpha <= 0 {
+			return fmt.Errorf("alpha must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown score distribution %q", o.Distribution)
+	}
+	total := 0.0
+	for _, w := range o.ClassWeights {
+		if w < 0 {
+			return fmt.Errorf("class weights must not be negative")
+		}
+		total += w
+	}
+	if total == 0 {
+		return fmt.Errorf("no class has a positive weight")
+	}
+	return nil
+}
+
+// Generator produces the accounts of a dataset in ID order.
+type Generator struct {
+	opts     Options
+	classIDs []int
+	cumul    []float64
+}
+
+// NewGenerator returns a generator for opts, which must be valid.
+func NewGenerator(opts Options) *Generator {
+	g := &Generator{opts: opts}
+	for id := range opts.ClassWeights {
+		g.classIDs = append(g.classIDs, id)
+	}
+	// Map order is random; class picks must not be
+	sort.Ints(g.classIDs)
+	total := 0.0
+	for _, id := range g.classIDs {
+		total += opts.ClassWeights[id]
+		g.cumul = append(g.cumul, total)
+	}
+	return g
+}
+
+// Each calls fn for every account in ID order, stopping at the first error.
+// Character IDs are numbered consecutively across accounts.
+func (g *Generator) Each(fn func(a *Account) error) error {
+	charID := 0
+	for i := 1; i <= g.opts.Accounts; i++ {
+		a := g.account(i, &charID)
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) account(id int, charID *int) *Account {
+	r := rand.New(newSource(g.opts.Seed, id))
+
+	var username string
+	switch r.Intn(4) {
+	case 0:
+		username = pick(r, prefixes) + pick(r, suffixes)
+	case 1:
+		username = pick(r, names) + pick(r, suffixes)
+	case 2:
+		username = pick(r, prefixes) + pick(r, names)
+	default:
+		username = pick(r, names) + "_"
+	}
+	// The ID keeps usernames unique, in any letter case
+	username += strconv.FormatInt(int64(id), 36)
+
+	a := &Account{
+		ID:        id,
+		Username:  username,
+		Email:     fmt.Sprintf("seed%d@wira.com", id),
+		CreatedAt: epoch.Add(time.Duration(r.Int63n(int64(365 * 24 * time.Hour)))),
+	}
+
+	n := g.opts.MinCharacters + r.Intn(g.opts.MaxCharacters-g.opts.MinCharacters+1)
+	for j := 0; j < n; j++ {
+		*charID++
+		a.Characters = append(a.Characters, Character{
+			ID:         *charID,
+			ClassID:    g.class(r),
+			Score:      g.score(r),
+			AchievedAt: a.CreatedAt.Add(time.Duration(r.Int63n(int64(30 * 24 * time.Hour)))),
+		})
+	}
+	return a
+}
+
+func pick(r *rand.Rand, words []string) string {
+	return words[r.Intn(len(words))]
+}
+
+func (g *Generator) class(r *rand.Rand) int {
+	x := r.Float64() * g.cumul[len(g.cumul)-1]
+	for i, c := range g.cumul {
+		if x < c {
+			return g.classIDs[i]
+		}
+	}
+	return g.classIDs[len(g.classIDs)-1]
+}
+
+func (g *Generator) score(r *rand.Rand) int {
+	max := float64(g.opts.MaxScore)
+	var x float64
+	switch g.opts.Distribution {
+	case DistNormal:
+		x = max/2 + r.NormFloat64()*max/6
+	case DistPowerLaw:
+		// Inverse CDF of a Pareto distribution bounded to [max/100, max]
+		a := g.opts.Alpha
+		lo := math.Max(1, max/100)
+		u := r.Float64()
+		x = lo * math.Pow(1-u*(1-math.Pow(lo/max, a)), -1/a)
+	default:
+		x = r.Float64() * (max + 1)
+	}
+	return int(math.Max(0, math.Min(max, math.Floor(x))))
+}
+
+// source is a SplitMix64 generator. It is cheap to create, so each account
+// can have its own stream.
+type source struct {
+	state uint64
+}
+
+func newSource(seed int64, id int) *source {
+	s := &source{state: uint64(seed)}
+	s.state ^= s.Uint64() + uint64(id)*0x9e3779b97f4a7c15
+	return s
+}
+
+func (s *source) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func (s *source) Seed(seed int64) {
+	s.state = uint64(seed)
+}
@@ -1,7 +1,6 @@
 #!/bin/sh
 
-# Replace environment variables in config file
-envsubst < config.yaml.template > config.yaml
-
-# Start the application
-exec ./main
+# config.yaml expands ${VAR} references itself when it is loaded, so the
+# container's environment is all it needs. Arguments are passed through as
+# flags, e.g. -port 9090.
+exec ./main "$@"
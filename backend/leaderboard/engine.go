@@ -0,0 +1,373 @@
+package leaderboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"wira-assignment/cache"
+)
+
+var (
+	// ErrNotReady means the sorted sets have not been built (or were cleared)
+	// and callers should fall back to Postgres.
+	ErrNotReady = errors.New("leaderboard not ready")
+	// ErrNotRanked means the character has no score on the leaderboard.
+	ErrNotRanked = errors.New("character not ranked")
+)
+
+// Every key carries the {leaderboard} hash tag so that, under Redis Cluster,
+// they share a slot and the update script and swap can touch them together.
+const (
+	globalKey  = "{leaderboard}:global"
+	membersKey = "{leaderboard}:members"
+	sortKeys   = "{leaderboard}:sortkeys"
+	builtKey   = "{leaderboard}:built"
+	classKeys  = "{leaderboard}:class:*"
+
+	rebuildSuffix = ":rebuild"
+	loadBatchSize = 1000
+
+	// Largest microsecond timestamp that fits the 16 digit sort key field
+	maxMicros = 9999999999999999
+)
+
+func classKey(classID int) string {
+	return fmt.Sprintf("{leaderboard}:class:%d", classID)
+}
+
+// boardKey returns the global set for classID 0 and the class set otherwise.
+func boardKey(classID int) string {
+	if classID > 0 {
+		return classKey(classID)
+	}
+	return globalKey
+}
+
+// Sorted-set members encode the tiebreaker: the time the score was achieved
+// followed by the character ID, both inverted and zero padded. Redis orders
+// equal scores by member in reverse lexicographic order under ZREVRANGE, so
+// ties come out earliest-achieved first and then by ascending char_id, the
+// same order Postgres uses.
+func encodeMember(charID int, achievedAt time.Time) string {
+	return fmt.Sprintf("%016d%010d", maxMicros-achievedAt.UnixMicro(), math.MaxInt32-charID)
+}
+
+func decodeMember(member string) (int, error) {
+	if len(member) != 26 {
+		return 0, fmt.Errorf("invalid leaderboard member %q", member)
+	}
+	n, err := strconv.Atoi(member[16:])
+	if err != nil {
+		return 0, fmt.Errorf("invalid leaderboard member %q: %v", member, err)
+	}
+	return math.MaxInt32 - n, nil
+}
+
+// Member is one character on the leaderboard.
+type Member struct {
+	CharID     int       `json:"char_id"`
+	ClassID    int       `json:"class_id"`
+	Username   string    `json:"username"`
+	ClassName  string    `json:"class_name"`
+	AchievedAt time.Time `json:"achieved_at"`
+	Score      int       `json:"-"`
+}
+
+// Entry is a member read from a board. Position is its 1-based place in the
+// board's ordering; ties on score still get distinct positions.
+type Entry struct {
+	Member
+	Position int
+}
+
+// Engine keeps the active season's leaderboard in Redis sorted sets: one for
+// all characters and one per class, scored by reward_score. Character details
+// are kept in a hash so pages can be served without touching Postgres.
+type Engine struct {
+	rdb redis.UniversalClient
+}
+
+func NewEngine(rdb redis.UniversalClient) *Engine {
+	return &Engine{rdb: rdb}
+}
+
+// updateScript moves a character to its new member and score. The member
+// changes whenever achieved_at does, so the old one has to be removed in the
+// same step.
+var updateScript = redis.NewScript(`
+local old = redis.call('HGET', KEYS[3], ARGV[1])
+if old and old ~= ARGV[2] then
+	redis.call('ZREM', KEYS[1], old)
+	redis.call('ZREM', KEYS[2], old)
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[4], ARGV[1], ARGV[4])
+return 1
+`)
+
+// Update sets a character's score on the global and class boards.
+func (e *Engine) Update(ctx context.Context, m Member) error {
+	meta, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{globalKey, classKey(m.ClassID), sortKeys, membersKey}
+	err = updateScript.Run(ctx, e.rdb, keys, m.CharID, encodeMember(m.CharID, m.AchievedAt), m.Score, meta).Err()
+	if err != nil {
+		return fmt.Errorf("error updating leaderboard: %v", err)
+	}
+	return nil
+}
+
+// Invalidate marks the boards as stale so readers fall back to Postgres until
+// the next Replace.
+func (e *Engine) Invalidate(ctx context.Context) error {
+	return e.rdb.Del(ctx, builtKey).Err()
+}
+
+// Page returns limit entries starting at offset, highest score first, along
+// with the total number of ranked characters on the board.
+func (e *Engine) Page(ctx context.Context, classID, offset, limit int) ([]Entry, int, error) {
+	key := boardKey(classID)
+
+	pipe := e.rdb.Pipeline()
+	built := pipe.Exists(ctx, builtKey)
+	card := pipe.ZCard(ctx, key)
+	page := pipe.ZRevRangeWithScores(ctx, key, int64(offset), int64(offset+limit-1))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, fmt.Errorf("error reading leaderboard: %v", err)
+	}
+	if built.Val() == 0 {
+		return nil, 0, ErrNotReady
+	}
+
+	entries, err := e.hydrate(ctx, page.Val(), offset+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, int(card.Val()), nil
+}
+
+// Count returns the number of ranked characters on the board.
+func (e *Engine) Count(ctx context.Context, classID int) (int, error) {
+	pipe := e.rdb.Pipeline()
+	built := pipe.Exists(ctx, builtKey)
+	card := pipe.ZCard(ctx, boardKey(classID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("error reading leaderboard size: %v", err)
+	}
+	if built.Val() == 0 {
+		return 0, ErrNotReady
+	}
+	return int(card.Val()), nil
+}
+
+// CountAbove returns the number of characters on the board with a strictly
+// higher score.
+func (e *Engine) CountAbove(ctx context.Context, classID, score int) (int, error) {
+	n, err := e.rdb.ZCount(ctx, boardKey(classID), "("+strconv.Itoa(score), "+inf").Result()
+	if err != nil {
+		return 0, fmt.Errorf("error counting leaderboard scores: %v", err)
+	}
+	return int(n), nil
+}
+
+// Position returns a character's 1-based position and score on the board.
+func (e *Engine) Position(ctx context.Context, classID, charID int) (int, int, error) {
+	pipe := e.rdb.Pipeline()
+	built := pipe.Exists(ctx, builtKey)
+	sortKey := pipe.HGet(ctx, sortKeys, strconv.Itoa(charID))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, fmt.Errorf("error reading leaderboard member: %v", err)
+	}
+	if built.Val() == 0 {
+		return 0, 0, ErrNotReady
+	}
+	if sortKey.Err() == redis.Nil {
+		return 0, 0, ErrNotRanked
+	}
+
+	key := boardKey(classID)
+	pipe = e.rdb.Pipeline()
+	rank := pipe.ZRevRank(ctx, key, sortKey.Val())
+	score := pipe.ZScore(ctx, key, sortKey.Val())
+	if _, err := pipe.Exec(ctx); err != nil {
+		if err == redis.Nil {
+			return 0, 0, ErrNotRanked
+		}
+		return 0, 0, fmt.Errorf("error reading leaderboard rank: %v", err)
+	}
+	return int(rank.Val()) + 1, int(score.Val()), nil
+}
+
+// positionsScript looks up the positions of several characters at once.
+// Characters not on the board get position 0.
+var positionsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return false
+end
+local positions = {}
+for i, charID in ipairs(ARGV) do
+	local member = redis.call('HGET', KEYS[2], charID)
+	local rank = member and redis.call('ZREVRANK', KEYS[3], member)
+	positions[i] = rank and rank + 1 or 0
+end
+return positions
+`)
+
+// Positions returns the 1-based positions of several characters on the board
+// in one round trip, with 0 for characters that are not ranked.
+func (e *Engine) Positions(ctx context.Context, classID int, charIDs []int) ([]int, error) {
+	if len(charIDs) == 0 {
+		return []int{}, nil
+	}
+	args := make([]interface{}, len(charIDs))
+	for i, charID := range charIDs {
+		args[i] = charID
+	}
+
+	keys := []string{builtKey, sortKeys, boardKey(classID)}
+	res, err := positionsScript.Run(ctx, e.rdb, keys, args...).Int64Slice()
+	if err == redis.Nil {
+		return nil, ErrNotReady
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading leaderboard ranks: %v", err)
+	}
+
+	positions := make([]int, len(res))
+	for i, p := range res {
+		positions[i] = int(p)
+	}
+	return positions, nil
+}
+
+// hydrate attaches character details to a ZREVRANGE result. firstPosition is
+// the position of the first element.
+func (e *Engine) hydrate(ctx context.Context, zs []redis.Z, firstPosition int) ([]Entry, error) {
+	if len(zs) == 0 {
+		return []Entry{}, nil
+	}
+
+	fields := make([]string, len(zs))
+	for i, z := range zs {
+		charID, err := decodeMember(z.Member.(string))
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = strconv.Itoa(charID)
+	}
+
+	metas, err := e.rdb.HMGet(ctx, membersKey, fields...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading leaderboard members: %v", err)
+	}
+
+	entries := make([]Entry, len(zs))
+	for i, z := range zs {
+		raw, ok := metas[i].(string)
+		if !ok {
+			return nil, ErrNotReady
+		}
+		if err := json.Unmarshal([]byte(raw), &entries[i].Member); err != nil {
+			return nil, fmt.Errorf("error decoding leaderboard member: %v", err)
+		}
+		entries[i].Score = int(z.Score)
+		entries[i].Position = firstPosition + i
+	}
+	return entries, nil
+}
+
+// Replace atomically swaps the boards for the given members. The new sets are
+// built under temporary keys and renamed into place, so readers never see a
+// partially loaded leaderboard.
+func (e *Engine) Replace(ctx context.Context, members []Member) error {
+	classIDs := map[int]bool{}
+	tmp := func(key string) string { return key + rebuildSuffix }
+
+	// Clear leftovers from an interrupted rebuild
+	stale, err := e.scanKeys(ctx, "{leaderboard}:*"+rebuildSuffix)
+	if err != nil {
+		return err
+	}
+	if len(stale) > 0 {
+		if err := e.rdb.Del(ctx, stale...).Err(); err != nil {
+			return fmt.Errorf("error clearing stale rebuild keys: %v", err)
+		}
+	}
+
+	for start := 0; start < len(members); start += loadBatchSize {
+		end := start + loadBatchSize
+		if end > len(members) {
+			end = len(members)
+		}
+
+		pipe := e.rdb.Pipeline()
+		for _, m := range members[start:end] {
+			meta, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			member := encodeMember(m.CharID, m.AchievedAt)
+			z := &redis.Z{Score: float64(m.Score), Member: member}
+			pipe.ZAdd(ctx, tmp(globalKey), z)
+			pipe.ZAdd(ctx, tmp(classKey(m.ClassID)), z)
+			pipe.HSet(ctx, tmp(sortKeys), strconv.Itoa(m.CharID), member)
+			pipe.HSet(ctx, tmp(membersKey), strconv.Itoa(m.CharID), meta)
+			classIDs[m.ClassID] = true
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("error loading leaderboard: %v", err)
+		}
+	}
+
+	oldClassKeys, err := e.scanKeys(ctx, classKeys)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, globalKey, sortKeys, membersKey)
+		for _, key := range oldClassKeys {
+			if !strings.HasSuffix(key, rebuildSuffix) {
+				pipe.Del(ctx, key)
+			}
+		}
+		if len(members) > 0 {
+			pipe.Rename(ctx, tmp(globalKey), globalKey)
+			pipe.Rename(ctx, tmp(sortKeys), sortKeys)
+			pipe.Rename(ctx, tmp(membersKey), membersKey)
+			for classID := range classIDs {
+				pipe.Rename(ctx, tmp(classKey(classID)), classKey(classID))
+			}
+		}
+		pipe.Set(ctx, builtKey, "1", 0)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error swapping leaderboard: %v", err)
+	}
+	return nil
+}
+
+func (e *Engine) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	err := cache.ScanKeys(ctx, e.rdb, pattern, func(batch []string) error {
+		keys = append(keys, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning leaderboard keys: %v", err)
+	}
+	return keys, nil
+}
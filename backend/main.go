@@ -1,176 +1,168 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math"
-	"net/http"
-	"regexp"
-	"strconv"
-	"strings"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	_ "github.com/lib/pq"
 	"wira-assignment/auth"
 	"wira-assignment/cache"
 	"wira-assignment/config"
+	"wira-assignment/db/migrations"
+	"wira-assignment/db/seed"
+	"wira-assignment/leaderboard"
 	"wira-assignment/ranking"
 )
 
-var (
-	db          *sql.DB
-	rankingRepo *ranking.Repository
-	cfg         *config.Config
-)
-
-func init() {
-	var err error
-	// Load configuration
-	cfg, err = config.LoadConfig()
+func main() {
+	// Load configuration. Flags such as -config come before a subcommand
+	cfg, args, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatal("Failed to load config:", err)
+		log.Fatal("Failed to load config: ", err)
+	}
+
+	// "migrate", "seed" and "totp-keys" manage the database instead of
+	// starting the server
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			useMigrationKeys(cfg)
+			runCommand(cfg, migrations.Command, args[1:])
+		case "seed":
+			runCommand(cfg, seed.Command, args[1:])
+		case "totp-keys":
+			useKeyRing(cfg)
+			runCommand(cfg, auth.KeysCommand, args[1:])
+		default:
+			log.Fatalf("Unknown command %q; expected migrate, seed or totp-keys", args[0])
+		}
+		return
 	}
 
-	// Initialize JWT key
-	auth.InitJWTKey(cfg.JWTSecret)
+	// Initialize JWT key and lifetimes
+	auth.InitJWTKey(cfg.JWT.Secret)
+	auth.SetExpiry(cfg.JWT.Expiry, cfg.Session.TTL)
+	auth.SetTOTPOptions(auth.TOTPOptions{
+		Digits:      cfg.TwoFactor.Digits,
+		Algorithm:   cfg.TwoFactor.Algorithm,
+		Skew:        cfg.TwoFactor.Skew,
+		MaxFailures: cfg.TwoFactor.MaxFailures,
+		Lockout:     cfg.TwoFactor.Lockout,
+		MaxLockout:  cfg.TwoFactor.MaxLockout,
+	})
+	useKeyRing(cfg)
 
-	// Initialize Redis
-	err = cache.InitRedis(cfg.RedisHost, cfg.RedisPort)
-	if err != nil {
-		log.Printf("Warning: Failed to initialize Redis: %v", err)
+	// Initialize the cache. A Redis cache degrades to the fallback store
+	// while Redis is unreachable instead of failing requests
+	var rdb redis.UniversalClient
+	if cfg.Cache.Backend == cache.BackendRedis {
+		fallback, err := cache.NewLocalStore(cfg.Cache.Fallback, cfg.Cache.MaxEntries)
+		if err != nil {
+			log.Fatal("Invalid cache fallback:", err)
+		}
+		redisErr := cache.InitRedis(cache.RedisOptions{
+			Mode:             cfg.Redis.Mode,
+			Addrs:            cfg.RedisAddrs(),
+			MasterName:       cfg.Redis.MasterName,
+			Username:         cfg.Redis.Username,
+			Password:         cfg.Redis.Password,
+			SentinelPassword: cfg.Redis.SentinelPassword,
+			DB:               cfg.Redis.DB,
+			TLS:              cfg.Redis.TLS,
+			TLSCAFile:        cfg.Redis.TLSCAFile,
+			TLSServerName:    cfg.Redis.TLSServerName,
+			PoolSize:         cfg.Redis.PoolSize,
+			DialTimeout:      cfg.Redis.DialTimeout,
+			ReadTimeout:      cfg.Redis.ReadTimeout,
+			WriteTimeout:     cfg.Redis.WriteTimeout,
+		})
+		if cache.Client() == nil {
+			// The options themselves are wrong, not the server
+			log.Fatal("Invalid Redis configuration:", redisErr)
+		}
+		store := cache.NewFallbackStore(cache.NewRedisStore(cache.Client()), fallback)
+		if redisErr != nil {
+			// The leaderboard stays on Postgres until the next restart
+			log.Printf("Warning: Failed to initialize Redis: %v", redisErr)
+			store.MarkDown(redisErr)
+		} else {
+			rdb = cache.Client()
+		}
+		go store.Watch(context.Background(), 10*time.Second)
+		cache.Use(store)
+	} else {
+		local, err := cache.NewLocalStore(cfg.Cache.Backend, cfg.Cache.MaxEntries)
+		if err != nil {
+			log.Fatal("Invalid cache backend:", err)
+		}
+		cache.Use(local)
 	}
 
 	// Initialize database connection
-	db, err = sql.Open("postgres", cfg.GetDBConnString())
+	db, err := sql.Open("postgres", cfg.GetDBConnString())
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+
 	if err = db.Ping(); err != nil {
 		log.Fatal(err)
 	}
 
-	rankingRepo = ranking.NewRepository(db)
-}
-
-func authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
-			c.Abort()
-			return
-		}
-
-		bearerToken := strings.Split(authHeader, " ")
-		if len(bearerToken) != 2 {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-			c.Abort()
-			return
-		}
-
-		claims, err := auth.ValidateToken(bearerToken[1])
+	// Bring the schema up to date, or warn that it is behind
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Database.AutoMigrate {
+		n, err := migrator.Up(context.Background())
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			c.Abort()
-			return
+			log.Fatal("Failed to migrate database: ", err)
 		}
+		log.Printf("%d migrations applied", n)
+	} else if err := migrator.Check(context.Background()); err != nil {
+		log.Printf("Warning: %v; run \"migrate up\" or enable database.auto_migrate", err)
+	}
 
-		c.Set("userID", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("claims", claims)
-		c.Next()
+	rankingRepo := ranking.NewRepository(db)
+	if rdb != nil {
+		rankingRepo.UseLeaderboard(leaderboard.NewEngine(rdb))
 	}
-}
+	rankingRepo.SetRankingsTTL(cache.TTL{Soft: cfg.Cache.SoftTTL, Hard: cfg.Cache.HardTTL})
 
-func main() {
 	r := gin.Default()
 
 	// CORS middleware
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://77.237.243.104:3000", "https://wira.aizat.dev"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization", "x-session-id"},
+		AllowOrigins:     cfg.Server.CORS.AllowedOrigins,
+		AllowMethods:     cfg.Server.CORS.AllowedMethods,
+		AllowHeaders:     cfg.Server.CORS.AllowedHeaders,
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
-		MaxAge:          12 * time.Hour,
+		MaxAge:           cfg.Server.CORS.MaxAge,
 	}))
 
-	// Public routes
-	authRouter := r.Group("/api/auth")
-	{
-		authRouter.POST("/register", handleRegister)
-		authRouter.POST("/login", handleLogin)
-		authRouter.POST("/2fa/login/verify", handle2FALogin)
-		
-		// Session validation endpoint
-		authRouter.POST("/validate-session", func(c *gin.Context) {
-			var req struct {
-				SessionID string `json:"sessionID" binding:"required"`
-			}
-			if err := c.ShouldBindJSON(&req); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-				return
-			}
-
-			session, err := auth.ValidateSession(db, req.SessionID)
-			if err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
-				return
-			}
-
-			c.JSON(http.StatusOK, gin.H{
-				"valid": true,
-				"session": session,
-			})
-		})
+	authHandler := auth.NewHandler(db, rdb)
 
-		// Logout endpoint
-		authRouter.POST("/logout", func(c *gin.Context) {
-			sessionID := c.GetHeader("X-Session-ID")
-			if sessionID == "" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID is required"})
-				return
-			}
-
-			err := auth.DeleteSession(db, sessionID)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
-				return
-			}
-
-			c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
-		})
-	}
-
-	// Protected routes
 	api := r.Group("/api")
-	api.Use(authMiddleware())
-	{
-		api.GET("/profile", getProfile)
-		api.POST("/cache/clear", handleClearCache)
-		api.GET("/rankings", getRankings)
-		api.GET("/rankings/:class", getRankingsByClass)
-		api.POST("/characters", createCharacter)
-		api.PUT("/characters/:id/score", updateScore)
-		api.GET("/search", searchRankings)
-		api.GET("/classes", func(c *gin.Context) {
-			classes, err := rankingRepo.GetClasses()
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch classes"})
-				return
-			}
-			c.JSON(http.StatusOK, classes)
-		})
+	protected := api.Group("", authHandler.RequireAuth())
+	signed := api.Group("", authHandler.RequireAuthOrAPIKey())
 
-		// 2FA routes
-		api.POST("/2fa/enable", handleEnable2FA)
-		api.POST("/2fa/verify", handleVerify2FA)
-		api.POST("/2fa/disable", handleDisable2FA)
-	}
+	cache.RegisterRoutes(api)
+	auth.RegisterRoutes(api, protected, authHandler)
+	ranking.RegisterRoutes(protected, signed, ranking.NewHandler(rankingRepo, authHandler))
 
 	// Start cleanup goroutine for expired sessions
 	go func() {
@@ -179,427 +171,88 @@ func main() {
 			if err := auth.DeleteExpiredSessions(db); err != nil {
 				log.Printf("Failed to cleanup expired sessions: %v", err)
 			}
+			if err := auth.DeleteExpiredNonces(db); err != nil {
+				log.Printf("Failed to cleanup API key nonces: %v", err)
+			}
+			if err := auth.DeleteExpiredLoginChallenges(db); err != nil {
+				log.Printf("Failed to cleanup login challenges: %v", err)
+			}
+			if err := auth.DeleteExpiredEnrollments(db); err != nil {
+				log.Printf("Failed to cleanup 2FA enrollments: %v", err)
+			}
 		}
 	}()
 
-	// Start server
-	if err := r.Run(fmt.Sprintf(":%s", cfg.ServerPort)); err != nil {
-		log.Fatal(err)
-	}
-}
-
-type RegisterRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
-	Email    string `json:"email" binding:"required,email"`
-}
-
-func handleRegister(c *gin.Context) {
-	var req RegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
-		return
-	}
-
-	if len(req.Username) < 3 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be at least 3 characters"})
-		return
-	}
-
-	if len(req.Password) < 6 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 6 characters"})
-		return
-	}
-
-	// Email validation
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	if !emailRegex.MatchString(req.Email) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
-		return
-	}
-
-	// Sanitize inputs
-	req.Username = strings.TrimSpace(req.Username)
-	req.Email = strings.TrimSpace(req.Email)
-	req.Password = strings.TrimSpace(req.Password)
-
-	err := auth.CreateUser(db, req.Username, req.Password, req.Email)
-	if err != nil {
-		if strings.Contains(err.Error(), "username already exists") {
-			c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
-			return
-		}
-		if strings.Contains(err.Error(), "email already exists") {
-			c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
+	// Load the leaderboard sorted sets; rankings are served from Postgres
+	// until this finishes
+	go func() {
+		count, err := rankingRepo.RebuildLeaderboard(context.Background())
+		if err != nil {
+			log.Printf("Warning: Failed to build leaderboard: %v", err)
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-		return
-	}
-
-	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
-}
-
-type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
-}
-
-type LoginResponse struct {
-	Token    string `json:"token,omitempty"`
-	User     *auth.User `json:"user,omitempty"`
-	Requires2FA bool `json:"requires_2fa,omitempty"`
-	SessionID string `json:"sessionID,omitempty"`
-}
-
-func handleLogin(c *gin.Context) {
-	var loginReq LoginRequest
-	if err := c.ShouldBindJSON(&loginReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-		return
-	}
-
-	user, err := auth.AuthenticateUser(db, loginReq.Username, loginReq.Password)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-		return
-	}
-
-	// Check if 2FA is enabled
-	twoFactorEnabled, _, err := auth.GetUser2FAStatus(db, user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check 2FA status"})
-		return
-	}
-
-	if twoFactorEnabled {
-		c.JSON(http.StatusOK, LoginResponse{
-			Requires2FA: true,
-		})
-		return
-	}
-
-	// Generate JWT token
-	token, err := auth.GenerateToken(*user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
-	}
-
-	// Create session
-	session, err := auth.CreateSession(db, user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
-		return
-	}
-
-	c.JSON(http.StatusOK, LoginResponse{
-		Token:    token,
-		User:     user,
-		SessionID: session.SessionID,
-	})
-}
-
-func getProfile(c *gin.Context) {
-	userID, _ := c.Get("userID")
-
-	var profile struct {
-		ID               int       `json:"id"`
-		Username         string    `json:"username"`
-		Email            string    `json:"email"`
-		CreatedAt        time.Time `json:"created_at"`
-		TwoFactorEnabled bool      `json:"two_factor_enabled"`
-	}
-
-	err := db.QueryRow(`
-        SELECT acc_id, username, email, created_at, two_factor_enabled
-        FROM accounts
-        WHERE acc_id = $1
-    `, userID).Scan(&profile.ID, &profile.Username, &profile.Email, &profile.CreatedAt, &profile.TwoFactorEnabled)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch profile"})
-		return
-	}
-
-	c.JSON(http.StatusOK, profile)
-}
-
-func getRankings(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	search := c.Query("search")
-	class := c.Query("class")
-
-	var classID int
-	var err error
+		log.Printf("Leaderboard built with %d characters", count)
+	}()
 
-	if class != "all" && class != "" {
-		classID, err = rankingRepo.GetClassIDByName(class)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class"})
-			return
+	// Roll seasons over once their scheduled end has passed
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			result, err := rankingRepo.RolloverDueSeason()
+			if err != nil {
+				if err != ranking.ErrRolloverNotDue {
+					log.Printf("Failed to roll over season: %v", err)
+				}
+				continue
+			}
+			log.Printf("Season %d archived, season %d started", result.Archived.ID, result.Current.ID)
 		}
-	}
+	}()
 
-	rankings, total, err := rankingRepo.GetRankings(classID, page, limit, search)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rankings"})
-		return
+	// Start server
+	if err := r.Run(fmt.Sprintf(":%s", cfg.Server.Port)); err != nil {
+		log.Fatal(err)
 	}
-
-	totalPages := int(math.Ceil(float64(total) / float64(limit)))
-
-	c.JSON(http.StatusOK, gin.H{
-		"rankings":     rankings,
-		"total":        total,
-		"current_page": page,
-		"total_pages":  totalPages,
-	})
 }
 
-func getRankingsByClass(c *gin.Context) {
-	classIDStr := c.Param("class")
-	classID, err := strconv.Atoi(classIDStr)
+// runCommand runs a database command against the configured database.
+func runCommand(cfg *config.Config, command func(context.Context, *sql.DB, []string, io.Writer) error, args []string) {
+	db, err := sql.Open("postgres", cfg.GetDBConnString())
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
-		return
+		log.Fatal(err)
 	}
+	defer db.Close()
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-
-	rankings, total, err := rankingRepo.GetRankings(classID, page, limit, "")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rankings"})
-		return
+	if err := command(context.Background(), db, args, os.Stdout); err != nil {
+		log.Fatal(err)
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"rankings": rankings,
-		"total":    total,
-	})
 }
 
-type CreateCharacterRequest struct {
-	ClassID int `json:"class_id" binding:"required"`
-}
-
-func createCharacter(c *gin.Context) {
-	var req CreateCharacterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	userID, _ := c.Get("userID")
-	err := rankingRepo.CreateCharacter(userID.(int), req.ClassID)
+// useKeyRing sets the keys TOTP secrets and API signing keys are encrypted
+// with, and registers the migration steps that encrypt the ones stored
+// before.
+func useKeyRing(cfg *config.Config) {
+	ring, err := auth.ParseKeyRing(cfg.TwoFactor.EncryptionKeys, cfg.TwoFactor.ActiveKey)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create character"})
-		return
+		log.Fatal("Invalid TOTP encryption keys: ", err)
 	}
-
-	c.JSON(http.StatusCreated, gin.H{"message": "Character created successfully"})
+	auth.SetKeyRing(ring)
+	migrations.RegisterStep(19, auth.SealPlaintextSecrets)
+	migrations.RegisterStep(21, auth.SealAPISigningKeys)
 }
 
-type UpdateScoreRequest struct {
-	Score int `json:"score" binding:"required"`
-}
-
-func updateScore(c *gin.Context) {
-	charIDStr := c.Param("id")
-	charID, err := strconv.Atoi(charIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+// useMigrationKeys registers the migration steps that encrypt stored
+// secrets. Only migrations 19 and 21 need the key ring, so without one
+// configured their steps fail instead, and a database past them migrates
+// without keys.
+func useMigrationKeys(cfg *config.Config) {
+	if len(cfg.TwoFactor.EncryptionKeys) > 0 {
+		useKeyRing(cfg)
 		return
 	}
-
-	var req UpdateScoreRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	noKeys := func(context.Context, *sql.Tx) error {
+		return errors.New("two_factor.encryption_keys is required to encrypt stored secrets (TOTP_ENCRYPTION_KEYS or TOTP_ENCRYPTION_KEYS_FILE)")
 	}
-
-	err = rankingRepo.UpdateScore(charID, req.Score)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update score"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Score updated successfully"})
-}
-
-func searchRankings(c *gin.Context) {
-	username := c.Query("username")
-	classIDStr := c.Query("classId")
-	
-	if username == "" && classIDStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide search parameters"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Search rankings endpoint"})
-}
-
-func handleClearCache(c *gin.Context) {
-    ctx := c.Request.Context()
-    err := cache.ClearAll(ctx)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cache"})
-        return
-    }
-    c.JSON(http.StatusOK, gin.H{"message": "Cache cleared successfully"})
-}
-
-type Enable2FARequest struct {
-    Password string `json:"password"`
-}
-
-type Verify2FARequest struct {
-    Code   string `json:"code"`
-    Secret string `json:"secret"`
-}
-
-func handleEnable2FA(c *gin.Context) {
-    claims, exists := c.Get("claims")
-    if !exists {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-        return
-    }
-
-    userClaims := claims.(*auth.Claims)
-    var req Enable2FARequest
-    if err := c.BindJSON(&req); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
-        return
-    }
-
-    // Verify password
-    var passwordHash string
-    err := db.QueryRow("SELECT password_hash FROM accounts WHERE acc_id = $1", userClaims.UserID).Scan(&passwordHash)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
-        return
-    }
-
-    if !auth.CheckPasswordHash(req.Password, passwordHash) {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
-        return
-    }
-
-    // Generate 2FA secret
-    secret, err := auth.GenerateSecret()
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
-        return
-    }
-
-    // Create QR code
-    qrURL := fmt.Sprintf("otpauth://totp/Wira:%s?secret=%s&issuer=Wira", userClaims.Username, secret)
-    
-    c.JSON(http.StatusOK, gin.H{
-        "secret": secret,
-        "qr_url": qrURL,
-    })
-}
-
-func handleVerify2FA(c *gin.Context) {
-    claims, exists := c.Get("claims")
-    if !exists {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-        return
-    }
-
-    userClaims := claims.(*auth.Claims)
-    var req Verify2FARequest
-    if err := c.BindJSON(&req); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
-        return
-    }
-
-    // Validate TOTP code using the provided secret
-    if !auth.ValidateTOTP(req.Secret, req.Code) {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
-        return
-    }
-
-    // Enable 2FA with the validated secret
-    if err := auth.Enable2FA(db, userClaims.UserID, req.Secret); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable 2FA"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{"message": "2FA enabled successfully"})
-}
-
-func handleDisable2FA(c *gin.Context) {
-    claims, exists := c.Get("claims")
-    if !exists {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-        return
-    }
-
-    userClaims := claims.(*auth.Claims)
-    err := auth.Disable2FA(db, userClaims.UserID)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            c.JSON(http.StatusNotFound, gin.H{"error": "2FA not enabled for this user"})
-            return
-        }
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable 2FA"})
-        return
-    }
-
-    c.JSON(http.StatusOK, gin.H{"message": "2FA disabled successfully"})
-}
-
-func handle2FALogin(c *gin.Context) {
-	var req struct {
-		Username string `json:"username" binding:"required"`
-		Code     string `json:"code" binding:"required"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-		return
-	}
-
-	// Get user and 2FA secret
-	var user auth.User
-	var secret string
-	err := db.QueryRow(`
-		SELECT acc_id, username, email, password_hash, two_factor_secret 
-		FROM accounts 
-		WHERE username = $1 AND two_factor_enabled = true`,
-		req.Username,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &secret)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-		return
-	}
-
-	// Validate TOTP code
-	if !auth.ValidateTOTP(secret, req.Code) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid 2FA code"})
-		return
-	}
-
-	// Generate JWT token
-	token, err := auth.GenerateToken(user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
-	}
-
-	// Create session
-	session, err := auth.CreateSession(db, user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
-		return
-	}
-
-	c.JSON(http.StatusOK, LoginResponse{
-		Token:     token,
-		User:      &user,
-		SessionID: session.SessionID,
-	})
+	migrations.RegisterStep(19, noKeys)
+	migrations.RegisterStep(21, noKeys)
 }
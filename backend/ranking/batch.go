@@ -0,0 +1,191 @@
+package ranking
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+	"wira-assignment/leaderboard"
+)
+
+// MaxBatchSize caps how many score updates one batch may carry
+const MaxBatchSize = 500
+
+// Per-item outcomes of a score batch
+const (
+	BatchApplied   = "applied"
+	BatchDuplicate = "duplicate"
+	BatchNotFound  = "not_found"
+	BatchInvalid   = "invalid"
+)
+
+var ErrBatchTooLarge = fmt.Errorf("batch exceeds %d updates", MaxBatchSize)
+
+// uniqueViolation is the Postgres error code for a duplicate key
+const uniqueViolation = "23505"
+
+// UpdateScores applies a batch of score updates in one transaction. Each item
+// runs under its own savepoint, so a bad item is reported without failing
+// the rest. Items whose idempotency key was already used by the submitter,
+// in this batch or an earlier one, are reported as duplicates and skipped.
+func (r *Repository) UpdateScores(updates []ScoreUpdate, submittedBy int) ([]ScoreUpdateResult, error) {
+	if len(updates) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]ScoreUpdateResult, len(updates))
+	keys := make([]string, 0, len(updates))
+	for i, u := range updates {
+		results[i] = ScoreUpdateResult{Index: i, IdempotencyKey: u.IdempotencyKey, CharID: u.CharID}
+		if u.IdempotencyKey != "" {
+			keys = append(keys, u.IdempotencyKey)
+		}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	seasonID, err := lockActiveSeason(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys already in the ledger
+	seen := make(map[string]int64)
+	rows, err := tx.Query(`
+		SELECT idempotency_key, event_id
+		FROM score_events
+		WHERE submitted_by = $1 AND idempotency_key = ANY($2)
+	`, submittedBy, pq.Array(keys))
+	if err != nil {
+		return nil, fmt.Errorf("error checking idempotency keys: %v", err)
+	}
+	for rows.Next() {
+		var key string
+		var eventID int64
+		if err := rows.Scan(&key, &eventID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning idempotency key: %v", err)
+		}
+		seen[key] = eventID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading idempotency keys: %v", err)
+	}
+
+	// Settle invalid items and reused keys in submitted order, so that of
+	// two items sharing a key the first one submitted is the one applied
+	firstWithKey := make(map[string]int)
+	for i, u := range updates {
+		res := &results[i]
+		switch {
+		case u.IdempotencyKey == "" || u.CharID <= 0:
+			res.Status = BatchInvalid
+			res.Error = "char_id and idempotency_key are required"
+		case u.Score < 0:
+			res.Status = BatchInvalid
+			res.Error = "score must not be negative"
+		case seen[u.IdempotencyKey] != 0:
+			res.Status = BatchDuplicate
+			res.EventID = seen[u.IdempotencyKey]
+		default:
+			if _, ok := firstWithKey[u.IdempotencyKey]; ok {
+				res.Status = BatchDuplicate
+			} else {
+				firstWithKey[u.IdempotencyKey] = i
+			}
+		}
+	}
+
+	// Lock characters in ID order so concurrent batches cannot deadlock;
+	// updates to the same character keep their submitted order
+	order := make([]int, 0, len(firstWithKey))
+	for i := range updates {
+		if results[i].Status == "" {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return updates[order[a]].CharID < updates[order[b]].CharID
+	})
+
+	members := make(map[int]leaderboard.Member)
+	for _, i := range order {
+		u := updates[i]
+		res := &results[i]
+
+		if _, err := tx.Exec("SAVEPOINT score_item"); err != nil {
+			return nil, fmt.Errorf("error creating savepoint: %v", err)
+		}
+
+		event, member, err := applyScore(tx, seasonID, u.CharID, u.Score, ScoreSubmission{
+			Source:         u.Source,
+			MatchID:        u.MatchID,
+			Metadata:       u.Metadata,
+			SubmittedBy:    submittedBy,
+			IdempotencyKey: u.IdempotencyKey,
+		})
+		if err != nil {
+			if _, rbErr := tx.Exec("ROLLBACK TO SAVEPOINT score_item"); rbErr != nil {
+				return nil, fmt.Errorf("error rolling back item: %v", rbErr)
+			}
+
+			// A concurrent batch may have used the key since we checked; it
+			// has committed by now, so its event can be read back
+			var pqErr *pq.Error
+			switch {
+			case err == ErrCharacterNotFound:
+				res.Status = BatchNotFound
+				res.Error = err.Error()
+			case errors.As(err, &pqErr) && pqErr.Code == uniqueViolation:
+				res.Status = BatchDuplicate
+				err = tx.QueryRow(`
+					SELECT event_id FROM score_events WHERE submitted_by = $1 AND idempotency_key = $2
+				`, submittedBy, u.IdempotencyKey).Scan(&res.EventID)
+				if err != nil && err != sql.ErrNoRows {
+					return nil, fmt.Errorf("error reading duplicate score event: %v", err)
+				}
+				seen[u.IdempotencyKey] = res.EventID
+			default:
+				return nil, err
+			}
+			continue
+		}
+
+		if _, err := tx.Exec("RELEASE SAVEPOINT score_item"); err != nil {
+			return nil, fmt.Errorf("error releasing savepoint: %v", err)
+		}
+
+		res.Status = BatchApplied
+		res.EventID = event.EventID
+		res.RewardScore = event.RewardScore
+		res.Delta = event.Delta
+		seen[u.IdempotencyKey] = event.EventID
+		members[u.CharID] = member
+	}
+
+	// Later items with a key used earlier in the batch point at its event
+	for i, u := range updates {
+		if results[i].Status == BatchDuplicate && results[i].EventID == 0 {
+			results[i].EventID = seen[u.IdempotencyKey]
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing scores: %v", err)
+	}
+
+	changed := make([]leaderboard.Member, 0, len(members))
+	for _, member := range members {
+		changed = append(changed, member)
+	}
+	r.scoresChanged(seasonID, changed)
+
+	return results, nil
+}
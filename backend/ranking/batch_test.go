@@ -0,0 +1,141 @@
+package ranking
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+)
+
+// accountOf returns the account that owns a character
+func accountOf(t *testing.T, db *sql.DB, charID int) int {
+	t.Helper()
+	var accID int
+	if err := db.QueryRow("SELECT acc_id FROM characters WHERE char_id = $1", charID).Scan(&accID); err != nil {
+		t.Fatal(err)
+	}
+	return accID
+}
+
+func TestUpdateScoresFirstSubmittedKeyWins(t *testing.T) {
+	db := openTestDB(t)
+	migrate(t, db, 1, math.MaxInt64)
+	repo := NewRepository(db)
+	low := createCharacter(t, db, "batch_low")
+	high := createCharacter(t, db, "batch_high")
+	submitter := accountOf(t, db, low)
+
+	// Items are applied in char_id order, but the key belongs to the first
+	// item submitted
+	results, err := repo.UpdateScores([]ScoreUpdate{
+		{IdempotencyKey: "match-1", CharID: high, Score: 10},
+		{IdempotencyKey: "match-1", CharID: low, Score: 20},
+	}, submitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != BatchApplied || results[1].Status != BatchDuplicate {
+		t.Fatalf("statuses = %s, %s, want %s, %s", results[0].Status, results[1].Status, BatchApplied, BatchDuplicate)
+	}
+	if results[1].EventID != results[0].EventID {
+		t.Errorf("duplicate points at event %d, want %d", results[1].EventID, results[0].EventID)
+	}
+	if got := liveScores(t, db, high); len(got) != 1 || got[0] != 10 {
+		t.Errorf("scores of the first item's character = %v, want [10]", got)
+	}
+	if got := liveScores(t, db, low); len(got) != 0 {
+		t.Errorf("scores of the duplicate's character = %v, want none", got)
+	}
+
+	// Resubmitting the batch applies nothing
+	results, err = repo.UpdateScores([]ScoreUpdate{{IdempotencyKey: "match-1", CharID: high, Score: 10}}, submitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != BatchDuplicate {
+		t.Errorf("resubmitted status = %s, want %s", results[0].Status, BatchDuplicate)
+	}
+}
+
+func TestUpdateScoresConcurrentDuplicateKey(t *testing.T) {
+	db := openTestDB(t)
+	migrate(t, db, 1, math.MaxInt64)
+	repo := NewRepository(db)
+	charID := createCharacter(t, db, "batch_concurrent")
+	other := createCharacter(t, db, "batch_concurrent_other")
+	submitter := accountOf(t, db, charID)
+
+	// The first batch records the key but has not committed yet, so the
+	// second one passes its idempotency check and waits on the character
+	first, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+	seasonID, err := lockActiveSeason(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, _, err := applyScore(first, seasonID, charID, 50, ScoreSubmission{
+		SubmittedBy:    submitter,
+		IdempotencyKey: "match-2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type batchResult struct {
+		results []ScoreUpdateResult
+		err     error
+	}
+	done := make(chan batchResult, 1)
+	go func() {
+		results, err := repo.UpdateScores([]ScoreUpdate{
+			{IdempotencyKey: "match-2", CharID: charID, Score: 60},
+			{IdempotencyKey: "match-3", CharID: other, Score: 70},
+		}, submitter)
+		done <- batchResult{results, err}
+	}()
+
+	waitForLockWait(t, db)
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := <-done
+	if second.err != nil {
+		t.Fatalf("overlapping batch = %v, want the item reported as a duplicate", second.err)
+	}
+	if r := second.results[0]; r.Status != BatchDuplicate || r.EventID != event.EventID {
+		t.Errorf("reused key = %s for event %d, want %s for event %d", r.Status, r.EventID, BatchDuplicate, event.EventID)
+	}
+	if r := second.results[1]; r.Status != BatchApplied {
+		t.Errorf("other item = %s, want %s", r.Status, BatchApplied)
+	}
+	if got := liveScores(t, db, charID); len(got) != 1 || got[0] != 50 {
+		t.Errorf("scores = %v, want [50] from the first batch", got)
+	}
+}
+
+// waitForLockWait blocks until another session is waiting on a lock
+func waitForLockWait(t *testing.T, db *sql.DB) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var waiting int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM pg_stat_activity
+			WHERE datname = current_database() AND wait_event_type = 'Lock'
+		`).Scan(&waiting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waiting > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the second batch never waited on the first")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
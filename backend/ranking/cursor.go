@@ -0,0 +1,288 @@
+package ranking
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor marks a row in the leaderboard's listing order. Clients treat the
+// encoded form as opaque.
+type cursor struct {
+	Score      int       `json:"s"`
+	AchievedAt time.Time `json:"a"`
+	CharID     int       `json:"c"`
+	Before     bool      `json:"b,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.CharID < 1 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// liveSource selects the active season's leaderboard with the same columns as
+// archivedSource.
+const liveSource = `(
+	SELECT s.char_id, s.reward_score, s.achieved_at, u.username, c.name AS class_name, ch.class_id
+	FROM scores s
+	JOIN characters ch ON s.char_id = ch.char_id
+	JOIN accounts u ON ch.acc_id = u.acc_id
+	JOIN classes c ON ch.class_id = c.id
+)`
+
+// nameContains matches rows of alias whose username contains the parameter
+// n, which must be lowercased and escaped with escapeLike. On the live
+// source this is the predicate the trigram index serves.
+func nameContains(alias string, n int) string {
+	return fmt.Sprintf("LOWER(%s.username) LIKE '%%' || $%d || '%%'", alias, n)
+}
+
+// searchRankings returns one page of the rows of source whose username
+// contains search. Matches are filtered before anything is ranked, and each
+// one on the page is then ranked over its class.
+func (r *Repository) searchRankings(source string, classID, page, limit int, search string, mode RankMode) ([]RankingEntry, int, error) {
+	classFilter := func(alias string) string { return " WHERE 1=1" }
+	args := []interface{}{escapeLike(strings.ToLower(search))}
+	if classID > 0 {
+		classFilter = func(alias string) string { return fmt.Sprintf(" WHERE %s.class_id = $2", alias) }
+		args = append(args, classID)
+	}
+	n := len(args) + 1
+
+	query := `
+		SELECT (` + mode.rankOf(source+" b2"+classFilter("b2"), "b2", columnKey("p")) + `) as rank,
+		       p.char_id, p.username, p.class_name, p.reward_score, p.achieved_at, p.total_count
+		FROM (
+			SELECT b.char_id, b.username, b.class_name, b.reward_score, b.achieved_at,
+			       COUNT(*) OVER() as total_count
+			FROM ` + source + ` b` + classFilter("b") + ` AND ` + nameContains("b", 1) + `
+			ORDER BY ` + rankOrder("b") + `
+			LIMIT $` + fmt.Sprint(n) + ` OFFSET $` + fmt.Sprint(n+1) + `
+		) p
+		ORDER BY ` + rankOrder("p")
+	args = append(args, limit, (page-1)*limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error searching rankings: %v", err)
+	}
+	defer rows.Close()
+
+	var rankings []RankingEntry
+	var totalCount int
+	for rows.Next() {
+		var entry RankingEntry
+		if err := rows.Scan(&entry.Rank, &entry.CharID, &entry.Username, &entry.ClassName, &entry.RewardScore, &entry.AchievedAt, &totalCount); err != nil {
+			return nil, 0, fmt.Errorf("error scanning ranking entry: %v", err)
+		}
+		rankings = append(rankings, entry)
+	}
+
+	return rankings, totalCount, rows.Err()
+}
+
+func cursorAt(entry RankingEntry, before bool) string {
+	return encodeCursor(cursor{Score: entry.RewardScore, AchievedAt: entry.AchievedAt, CharID: entry.CharID, Before: before})
+}
+
+// GetRankingsByCursor pages through a season's leaderboard with keyset
+// pagination. An empty cursor starts at the top. Unlike page numbers, a
+// cursor stays anchored to its row when scores above it change.
+func (r *Repository) GetRankingsByCursor(seasonID, classID, limit int, search, after string, mode RankMode) (*RankingResponse, error) {
+	ctx := context.Background()
+
+	cur, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both sources expose the same columns so the keyset logic is shared
+	source := liveSource
+	live := true
+	if seasonID > 0 {
+		season, err := r.GetSeason(seasonID)
+		if err != nil {
+			return nil, err
+		}
+		if season.Status == SeasonArchived {
+			live = false
+			source = archivedSource(season.ID)
+		}
+	}
+
+	// Ranks are taken over the class; the search only narrows which rows show
+	classFilter := func(alias string) string { return " WHERE 1=1" }
+	var args []interface{}
+	argCount := 1
+	if classID > 0 {
+		n := argCount
+		classFilter = func(alias string) string { return fmt.Sprintf(" WHERE %s.class_id = $%d", alias, n) }
+		args = append(args, classID)
+		argCount++
+	}
+	filter := classFilter("b")
+	if search != "" {
+		filter += " AND " + nameContains("b", argCount)
+		args = append(args, escapeLike(strings.ToLower(search)))
+		argCount++
+	}
+
+	columns := "b.char_id, b.username, b.class_name, b.reward_score, b.achieved_at"
+	if search != "" {
+		// Matches are not contiguous, so each one gets its own rank
+		columns += ", (" + mode.rankOf(source+" b2"+classFilter("b2"), "b2", columnKey("b")) + ")"
+	}
+	query := "SELECT " + columns + " FROM " + source + " b" + filter
+	pageArgs := append([]interface{}{}, args...)
+	order := " ORDER BY " + rankOrder("b")
+	if cur != nil {
+		if cur.Before {
+			query += " AND " + aheadOf("b", paramKey(argCount))
+			order = " ORDER BY b.reward_score, b.achieved_at DESC, b.char_id DESC"
+		} else {
+			query += " AND " + behind("b", paramKey(argCount))
+		}
+		pageArgs = append(pageArgs, cur.Score, cur.AchievedAt, cur.CharID)
+		argCount += 3
+	}
+	query += order + fmt.Sprintf(" LIMIT $%d", argCount)
+	pageArgs = append(pageArgs, limit+1)
+
+	rows, err := r.db.Query(query, pageArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying rankings: %v", err)
+	}
+	defer rows.Close()
+
+	rankings := []RankingEntry{}
+	for rows.Next() {
+		var entry RankingEntry
+		dest := []interface{}{&entry.CharID, &entry.Username, &entry.ClassName, &entry.RewardScore, &entry.AchievedAt}
+		if search != "" {
+			dest = append(dest, &entry.Rank)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("error scanning ranking entry: %v", err)
+		}
+		rankings = append(rankings, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rankings: %v", err)
+	}
+
+	hasMore := len(rankings) > limit
+	if hasMore {
+		rankings = rankings[:limit]
+	}
+	if cur != nil && cur.Before {
+		for i, j := 0, len(rankings)-1; i < j; i, j = i+1, j-1 {
+			rankings[i], rankings[j] = rankings[j], rankings[i]
+		}
+	}
+
+	response := &RankingResponse{Rankings: rankings}
+	useBoard := r.board != nil && live && search == ""
+	classOnly := source + " b" + classFilter("b")
+	classArgs := args
+	if search != "" {
+		classArgs = args[:len(args)-1]
+	}
+
+	if len(rankings) > 0 {
+		first := rankings[0]
+		if search == "" {
+			if err := r.rankContiguous(ctx, useBoard, classOnly, classArgs, classID, rankings, mode); err != nil {
+				return nil, err
+			}
+		}
+
+		// There is something above the first row unless it heads the listing
+		var ahead bool
+		query := "SELECT EXISTS(SELECT 1 FROM " + source + " b" + filter + " AND " + aheadOf("b", paramKey(len(args)+1)) + ")"
+		if err := r.db.QueryRow(query, append(append([]interface{}{}, args...), first.RewardScore, first.AchievedAt, first.CharID)...).Scan(&ahead); err != nil {
+			return nil, fmt.Errorf("error checking previous page: %v", err)
+		}
+
+		last := rankings[len(rankings)-1]
+		if (cur != nil && cur.Before) || hasMore {
+			response.NextCursor = cursorAt(last, false)
+		}
+		if ahead {
+			response.PrevCursor = cursorAt(first, true)
+		}
+	}
+
+	if useBoard {
+		total, err := r.board.Count(ctx, classID)
+		if err == nil {
+			response.TotalCount = total
+			return response, nil
+		}
+		r.boardError(err)
+	}
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM "+source+" b"+filter, args...).Scan(&response.TotalCount); err != nil {
+		return nil, fmt.Errorf("error counting rankings: %v", err)
+	}
+
+	return response, nil
+}
+
+// rankContiguous ranks a run of consecutive rows by finding the position and
+// rank of the first one.
+func (r *Repository) rankContiguous(ctx context.Context, useBoard bool, from string, args []interface{}, classID int, rankings []RankingEntry, mode RankMode) error {
+	first := rankings[0]
+
+	if useBoard && mode != RankModeDense {
+		position, _, err := r.board.Position(ctx, classID, first.CharID)
+		if err == nil {
+			rank := position
+			if mode == RankModeStandard {
+				above, err := r.board.CountAbove(ctx, classID, first.RewardScore)
+				if err != nil {
+					return err
+				}
+				rank = above + 1
+			}
+			assignRanks(rankings, mode, position, rank)
+			return nil
+		}
+		r.boardError(err)
+	}
+
+	n := len(args) + 1
+	var position, rank int
+	err := r.db.QueryRow(RankModeRow.rankOf(from, "b", paramKey(n)), append(append([]interface{}{}, args...), RankModeRow.rankOfArgs(first)...)...).Scan(&position)
+	if err != nil {
+		return fmt.Errorf("error computing position: %v", err)
+	}
+	rank = position
+	if mode != RankModeRow {
+		err = r.db.QueryRow(mode.rankOf(from, "b", paramKey(n)), append(append([]interface{}{}, args...), mode.rankOfArgs(first)...)...).Scan(&rank)
+		if err != nil {
+			return fmt.Errorf("error computing rank: %v", err)
+		}
+	}
+	assignRanks(rankings, mode, position, rank)
+	return nil
+}
@@ -1,125 +1,602 @@
 package ranking
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"wira-assignment/auth"
+	"wira-assignment/cache"
 )
 
+// Authorizer guards endpoints by permission and records audit events. It is
+// implemented by auth.Handler.
+type Authorizer interface {
+	Require(perms ...auth.Permission) gin.HandlerFunc
+	Audit(c *gin.Context, event auth.AuditEvent)
+}
+
 type Handler struct {
-	repo *Repository
+	repo  *Repository
+	authz Authorizer
 }
 
-func NewHandler(repo *Repository) *Handler {
+func NewHandler(repo *Repository, authz Authorizer) *Handler {
 	return &Handler{
-		repo: repo,
+		repo:  repo,
+		authz: authz,
 	}
 }
 
+// RegisterRoutes mounts the ranking endpoints. Character management is only
+// for user tokens on protected; the leaderboard reads and score submissions
+// go on signed, which also accepts game server API keys, so each of them
+// checks its permission.
+func RegisterRoutes(protected, signed *gin.RouterGroup, h *Handler) {
+	read := h.authz.Require(auth.PermScoresRead)
+
+	protected.GET("/characters", h.GetUserCharacters)
+	protected.POST("/characters", h.CreateCharacter)
+	protected.GET("/characters/:id/score-history", h.GetScoreHistory)
+	signed.PUT("/characters/:id/score", h.authz.Require(auth.PermScoresWrite, auth.PermScoresCorrect), h.UpdateScore)
+	signed.POST("/scores/batch", h.authz.Require(auth.PermScoresWrite), h.UpdateScores)
+
+	signed.GET("/classes", read, h.GetClasses)
+	signed.GET("/rankings", read, h.GetRankings)
+	signed.GET("/rankings/around/:charId", read, h.GetRankingsAround)
+	signed.GET("/rankings/:class", read, h.GetRankingsByClass)
+	signed.GET("/search", read, h.Search)
+	signed.GET("/players/suggest", read, h.Suggest)
+
+	signed.GET("/seasons", read, h.GetSeasons)
+	signed.POST("/seasons/rollover", h.authz.Require(auth.PermSeasonsManage), h.RolloverSeason)
+	signed.POST("/leaderboard/rebuild", h.authz.Require(auth.PermLeaderboardManage), h.RebuildLeaderboard)
+	signed.POST("/cache/clear", h.authz.Require(auth.PermLeaderboardManage), h.ClearCache)
+}
+
 func (h *Handler) GetClasses(c *gin.Context) {
 	classes, err := h.repo.GetClasses()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch classes"})
 		return
 	}
 	c.JSON(http.StatusOK, classes)
 }
 
 func (h *Handler) GetUserCharacters(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
-		return
-	}
+	userID := c.GetInt("userID")
 
-	characters, err := h.repo.GetUserCharacters(userID.(int))
+	characters, err := h.repo.GetUserCharacters(userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch characters"})
 		return
 	}
 
 	c.JSON(http.StatusOK, characters)
 }
 
+type CreateCharacterRequest struct {
+	ClassID int `json:"class_id" binding:"required"`
+}
+
 func (h *Handler) CreateCharacter(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+	var req CreateCharacterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var req struct {
-		ClassID int `json:"class_id" binding:"required"`
+	err := h.repo.CreateCharacter(c.GetInt("userID"), req.ClassID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create character"})
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+	c.JSON(http.StatusCreated, gin.H{"message": "Character created successfully"})
+}
+
+// parseSeason reads the season query parameter. An empty value or "current"
+// selects the active season and is returned as 0.
+func parseSeason(value string) (int, error) {
+	if value == "" || value == "current" {
+		return 0, nil
+	}
+	seasonID, err := strconv.Atoi(value)
+	if err != nil || seasonID < 1 {
+		return 0, fmt.Errorf("invalid season: %s", value)
+	}
+	return seasonID, nil
+}
+
+// parseClass resolves the class query parameter by name; "all" or an empty
+// value selects every class and is returned as 0.
+func (h *Handler) parseClass(value string) (int, error) {
+	if value == "" || value == "all" {
+		return 0, nil
+	}
+	return h.repo.GetClassIDByName(value)
+}
+
+func (h *Handler) GetRankings(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	search := c.Query("search")
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	seasonID, err := parseSeason(c.Query("season"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid season"})
+		return
+	}
+
+	mode, err := ParseRankMode(c.Query("rank_mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rank mode"})
 		return
 	}
 
-	err := h.repo.CreateCharacter(userID.(int), req.ClassID)
+	classID, err := h.parseClass(c.Query("class"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class"})
+		return
+	}
+
+	// Cursor mode is selected by passing a cursor (empty for the first page)
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		response, err := h.repo.GetRankingsByCursor(seasonID, classID, limit, search, cursor, mode)
+		if err != nil {
+			switch err {
+			case ErrInvalidCursor:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			case ErrSeasonNotFound:
+				c.JSON(http.StatusNotFound, gin.H{"error": "Season not found"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rankings"})
+			}
+			return
+		}
+		c.JSON(http.StatusOK, response)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "character created successfully"})
+	h.rankingsPage(c, seasonID, classID, page, limit, search, mode)
 }
 
-func (h *Handler) GetRankings(c *gin.Context) {
-	// Get query parameters with defaults
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	search := c.DefaultQuery("search", "")
-	classID, _ := strconv.Atoi(c.DefaultQuery("classId", "0"))
+func (h *Handler) GetRankingsByClass(c *gin.Context) {
+	classID, err := strconv.Atoi(c.Param("class"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+		return
+	}
 
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	if page < 1 {
 		page = 1
 	}
-	if limit < 1 {
-		limit = 10
+	if limit < 1 || limit > 100 {
+		limit = 20
 	}
 
-	var rankings []RankingEntry
-	var totalCount int
-	var err error
+	seasonID, err := parseSeason(c.Query("season"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid season"})
+		return
+	}
 
-	rankings, totalCount, err = h.repo.GetRankings(classID, page, limit, search)
+	mode, err := ParseRankMode(c.Query("rank_mode"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rank mode"})
 		return
 	}
 
-	totalPages := int(math.Ceil(float64(totalCount) / float64(limit)))
+	h.rankingsPage(c, seasonID, classID, page, limit, "", mode)
+}
+
+func (h *Handler) rankingsPage(c *gin.Context, seasonID, classID, page, limit int, search string, mode RankMode) {
+	rankings, total, err := h.repo.GetRankings(seasonID, classID, page, limit, search, mode)
+	if err != nil {
+		if err == ErrSeasonNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Season not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rankings"})
+		return
+	}
 
-	response := RankingResponse{
+	c.JSON(http.StatusOK, RankingResponse{
 		Rankings:    rankings,
-		TotalCount:  totalCount,
+		TotalCount:  total,
 		CurrentPage: page,
-		TotalPages:  totalPages,
+		TotalPages:  int(math.Ceil(float64(total) / float64(limit))),
+	})
+}
+
+func (h *Handler) GetRankingsAround(c *gin.Context) {
+	charID, err := strconv.Atoi(c.Param("charId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	radius, err := strconv.Atoi(c.DefaultQuery("radius", "5"))
+	if err != nil || radius < 0 || radius > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Radius must be between 0 and 50"})
+		return
+	}
+
+	mode, err := ParseRankMode(c.Query("rank_mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rank mode"})
+		return
+	}
+
+	classID, err := h.parseClass(c.Query("class"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class"})
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	around, err := h.repo.GetRankingsAround(classID, charID, radius, mode)
+	if err != nil {
+		if err == ErrNotRanked {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Character is not ranked"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rankings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, around)
+}
+
+// Score is a pointer so that required accepts a submitted 0 and only
+// rejects a missing field.
+type UpdateScoreRequest struct {
+	Score    *int            `json:"score" binding:"required,min=0"`
+	Source   string          `json:"source"`
+	MatchID  string          `json:"match_id"`
+	Metadata json.RawMessage `json:"match_metadata"`
 }
 
 func (h *Handler) UpdateScore(c *gin.Context) {
-	var req struct {
-		CharID int `json:"char_id" binding:"required"`
-		Score  int `json:"score" binding:"required"`
+	charID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	var req UpdateScoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
+	// Game server keys submit match results; anyone else reaching this point
+	// is an admin, whose changes are always recorded as corrections
+	source := req.Source
+	if auth.HasPermission(c, auth.PermScoresWrite) {
+		if source == ScoreSourceCorrection {
+			h.authz.Audit(c, auth.AuditEvent{
+				Action:   "score_update",
+				Outcome:  auth.AuditDenied,
+				Reason:   "corrections require scores:correct",
+				Metadata: map[string]interface{}{"char_id": charID, "score": *req.Score},
+			})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+	} else {
+		source = ScoreSourceCorrection
+	}
+
+	event, err := h.repo.UpdateScore(charID, *req.Score, ScoreSubmission{
+		Source:      source,
+		MatchID:     req.MatchID,
+		Metadata:    req.Metadata,
+		SubmittedBy: c.GetInt("userID"),
+	})
+	if err != nil {
+		if err == ErrCharacterNotFound {
+			h.authz.Audit(c, auth.AuditEvent{
+				Action:   "score_update",
+				Outcome:  auth.AuditDenied,
+				Reason:   "character not found",
+				Metadata: map[string]interface{}{"char_id": charID, "score": *req.Score},
+			})
+			c.JSON(http.StatusNotFound, gin.H{"error": "Character not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update score"})
+		return
+	}
+
+	if source == ScoreSourceCorrection {
+		h.authz.Audit(c, auth.AuditEvent{
+			Action:   "score_correction",
+			Outcome:  auth.AuditAllowed,
+			Metadata: map[string]interface{}{"char_id": charID, "score": *req.Score, "event_id": event.EventID},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Score updated successfully",
+		"event":   event,
+	})
+}
+
+type UpdateScoresRequest struct {
+	Updates []ScoreUpdate `json:"updates" binding:"required"`
+}
+
+func (h *Handler) UpdateScores(c *gin.Context) {
+	var req UpdateScoresRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Updates) > MaxBatchSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrBatchTooLarge.Error()})
+		return
+	}
+
+	// Corrections go through the single-score endpoint
+	for i := range req.Updates {
+		if req.Updates[i].Source == ScoreSourceCorrection {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Corrections cannot be submitted in a batch"})
+			return
+		}
+	}
+
+	results, err := h.repo.UpdateScores(req.Updates, c.GetInt("userID"))
+	if err != nil {
+		log.Printf("Score batch failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scores"})
+		return
+	}
+
+	summary := map[string]int{}
+	for _, res := range results {
+		summary[res.Status]++
+		if res.Status == BatchNotFound || res.Status == BatchInvalid {
+			h.authz.Audit(c, auth.AuditEvent{
+				Action:   "score_update",
+				Outcome:  auth.AuditDenied,
+				Reason:   res.Error,
+				Metadata: map[string]interface{}{"char_id": res.CharID, "idempotency_key": res.IdempotencyKey},
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"summary": summary,
+	})
+}
+
+func (h *Handler) GetScoreHistory(c *gin.Context) {
+	charID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	var from, to time.Time
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time, expected RFC3339"})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time, expected RFC3339"})
+			return
+		}
+	}
+
+	events, total, err := h.repo.GetScoreHistory(charID, from, to, page, limit)
+	if err != nil {
+		if err == ErrCharacterNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Character not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch score history"})
 		return
 	}
 
-	err := h.repo.UpdateScore(req.CharID, req.Score)
+	c.JSON(http.StatusOK, ScoreHistoryResponse{
+		CharID:      charID,
+		Events:      events,
+		TotalCount:  total,
+		CurrentPage: page,
+		TotalPages:  int(math.Ceil(float64(total) / float64(limit))),
+	})
+}
+
+func (h *Handler) Search(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	mode, err := ParseRankMode(c.Query("rank_mode"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rank mode"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "score updated successfully"})
+	filter := SearchFilter{
+		Username:   strings.TrimSpace(c.Query("username")),
+		Match:      c.Query("match"),
+		Race:       c.Query("race"),
+		CombatType: c.Query("combat_type"),
+		Page:       page,
+		Limit:      limit,
+		Mode:       mode,
+	}
+
+	// Accept either a class name or a numeric class ID
+	if class := c.Query("class"); class != "" {
+		if filter.ClassID, err = h.parseClass(class); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class"})
+			return
+		}
+	} else if v := c.Query("classId"); v != "" {
+		if filter.ClassID, err = strconv.Atoi(v); err != nil || filter.ClassID < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid class ID"})
+			return
+		}
+	}
+
+	for name, dst := range map[string]**int{"min_score": &filter.MinScore, "max_score": &filter.MaxScore} {
+		if v := c.Query(name); v != "" {
+			score, err := strconv.Atoi(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return
+			}
+			*dst = &score
+		}
+	}
+
+	if v := c.Query("created_after"); v != "" {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid created_after time, expected RFC3339"})
+			return
+		}
+	}
+	if v := c.Query("created_before"); v != "" {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid created_before time, expected RFC3339"})
+			return
+		}
+	}
+
+	if filter.Username == "" && filter.ClassID == 0 && filter.Race == "" && filter.CombatType == "" &&
+		filter.MinScore == nil && filter.MaxScore == nil && filter.CreatedAfter.IsZero() && filter.CreatedBefore.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Please provide search parameters"})
+		return
+	}
+
+	results, total, err := h.repo.SearchPlayers(filter)
+	if err != nil {
+		if err == ErrInvalidMatch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid match mode, expected prefix, substring or fuzzy"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search rankings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SearchResponse{
+		Results:     results,
+		TotalCount:  total,
+		CurrentPage: page,
+		TotalPages:  int(math.Ceil(float64(total) / float64(limit))),
+	})
+}
+
+func (h *Handler) Suggest(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusOK, gin.H{"suggestions": []Suggestion{}})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "8"))
+	if limit < 1 || limit > 20 {
+		limit = 8
+	}
+
+	suggestions, err := h.repo.SuggestPlayers(c.Request.Context(), q, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
+
+func (h *Handler) GetSeasons(c *gin.Context) {
+	seasons, err := h.repo.ListSeasons()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seasons"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"seasons": seasons})
+}
+
+type RolloverSeasonRequest struct {
+	Name   string    `json:"name"`
+	EndsAt time.Time `json:"ends_at"`
+}
+
+func (h *Handler) RolloverSeason(c *gin.Context) {
+	var req RolloverSeasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !req.EndsAt.IsZero() && !req.EndsAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be in the future"})
+		return
+	}
+
+	result, err := h.repo.RolloverSeason(req.Name, req.EndsAt)
+	if err != nil {
+		log.Printf("Season rollover failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll over season"})
+		return
+	}
+
+	log.Printf("Season %d archived, season %d started", result.Archived.ID, result.Current.ID)
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) RebuildLeaderboard(c *gin.Context) {
+	count, err := h.repo.RebuildLeaderboard(c.Request.Context())
+	if err != nil {
+		log.Printf("Leaderboard rebuild failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild leaderboard"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Leaderboard rebuilt successfully",
+		"characters": count,
+	})
+}
+
+func (h *Handler) ClearCache(c *gin.Context) {
+	if err := cache.ClearAll(c.Request.Context()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cache"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cache cleared successfully"})
 }
@@ -0,0 +1,200 @@
+package ranking
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"wira-assignment/leaderboard"
+)
+
+// UseLeaderboard serves active-season rankings from the Redis leaderboard
+// engine and keeps it in sync with score updates. Without an engine every
+// ranking query goes to Postgres.
+func (r *Repository) UseLeaderboard(board *leaderboard.Engine) {
+	r.board = board
+}
+
+// RebuildLeaderboard reloads the leaderboard engine from the scores table and
+// returns the number of characters loaded.
+func (r *Repository) RebuildLeaderboard(ctx context.Context) (int, error) {
+	if r.board == nil {
+		return 0, fmt.Errorf("leaderboard engine not configured")
+	}
+
+	// Scores written while the snapshot loads are replayed afterwards
+	started := time.Now().Add(-time.Second)
+
+	members, err := r.loadLeaderboardMembers("")
+	if err != nil {
+		return 0, err
+	}
+	if err := r.board.Replace(ctx, members); err != nil {
+		return 0, err
+	}
+
+	changed, err := r.loadLeaderboardMembers("WHERE s.updated_at >= $1", started)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range changed {
+		if err := r.board.Update(ctx, m); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(members), nil
+}
+
+func (r *Repository) loadLeaderboardMembers(where string, args ...interface{}) ([]leaderboard.Member, error) {
+	rows, err := r.db.Query(`
+		SELECT s.char_id, ch.class_id, u.username, c.name, s.reward_score, s.achieved_at
+		FROM scores s
+		JOIN characters ch ON s.char_id = ch.char_id
+		JOIN accounts u ON ch.acc_id = u.acc_id
+		JOIN classes c ON ch.class_id = c.id
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying leaderboard members: %v", err)
+	}
+	defer rows.Close()
+
+	var members []leaderboard.Member
+	for rows.Next() {
+		var m leaderboard.Member
+		if err := rows.Scan(&m.CharID, &m.ClassID, &m.Username, &m.ClassName, &m.Score, &m.AchievedAt); err != nil {
+			return nil, fmt.Errorf("error scanning leaderboard member: %v", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// invalidateLeaderboard sends readers to Postgres until a rebuild completes,
+// for when the sorted sets are known to be out of date.
+func (r *Repository) invalidateLeaderboard() {
+	if r.board == nil {
+		return
+	}
+	if err := r.board.Invalidate(context.Background()); err != nil {
+		log.Printf("Warning: Failed to invalidate leaderboard: %v", err)
+	}
+	r.refreshLeaderboard()
+}
+
+// refreshLeaderboard rebuilds the engine in the background. Requests made
+// while a rebuild is running are folded into one more rebuild afterwards, so
+// the final snapshot is never older than the last request.
+func (r *Repository) refreshLeaderboard() {
+	if r.board == nil {
+		return
+	}
+	atomic.StoreInt32(&r.refreshPending, 1)
+	if !atomic.CompareAndSwapInt32(&r.rebuilding, 0, 1) {
+		return
+	}
+	go func() {
+		for atomic.SwapInt32(&r.refreshPending, 0) == 1 {
+			count, err := r.RebuildLeaderboard(context.Background())
+			if err != nil {
+				log.Printf("Failed to rebuild leaderboard: %v", err)
+				continue
+			}
+			log.Printf("Leaderboard rebuilt with %d characters", count)
+		}
+		atomic.StoreInt32(&r.rebuilding, 0)
+		if atomic.LoadInt32(&r.refreshPending) == 1 {
+			r.refreshLeaderboard()
+		}
+	}()
+}
+
+// boardError logs a leaderboard failure before the caller falls back to
+// Postgres, and schedules a rebuild if the sorted sets are missing.
+func (r *Repository) boardError(err error) {
+	if err == leaderboard.ErrNotReady {
+		r.refreshLeaderboard()
+		return
+	}
+	log.Printf("Warning: leaderboard unavailable, using database: %v", err)
+}
+
+// boardRankings reads a page from the sorted sets. Dense ranks cannot be
+// derived from a sorted set cheaply, so callers must not use it for
+// RankModeDense.
+func (r *Repository) boardRankings(ctx context.Context, classID, page, limit int, mode RankMode) ([]RankingEntry, int, error) {
+	entries, total, err := r.board.Page(ctx, classID, (page-1)*limit, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rankings, err := r.boardEntries(ctx, classID, entries, mode)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rankings, total, nil
+}
+
+func (r *Repository) boardAround(ctx context.Context, classID, charID, radius int, mode RankMode) (*AroundResponse, error) {
+	position, _, err := r.board.Position(ctx, classID, charID)
+	if err != nil {
+		if err == leaderboard.ErrNotRanked {
+			return nil, ErrNotRanked
+		}
+		return nil, err
+	}
+
+	offset := position - 1 - radius
+	if offset < 0 {
+		offset = 0
+	}
+	entries, total, err := r.board.Page(ctx, classID, offset, position-offset+radius)
+	if err != nil {
+		return nil, err
+	}
+
+	rankings, err := r.boardEntries(ctx, classID, entries, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	around := &AroundResponse{Rankings: rankings, TotalCount: total}
+	for _, entry := range rankings {
+		if entry.CharID == charID {
+			around.Character = entry
+		}
+	}
+	return around, nil
+}
+
+// boardEntries converts a contiguous run of board entries, ranking them in
+// the requested mode.
+func (r *Repository) boardEntries(ctx context.Context, classID int, entries []leaderboard.Entry, mode RankMode) ([]RankingEntry, error) {
+	rankings := make([]RankingEntry, len(entries))
+	for i, e := range entries {
+		rankings[i] = RankingEntry{
+			CharID:      e.CharID,
+			Username:    e.Username,
+			ClassName:   e.ClassName,
+			RewardScore: e.Score,
+			AchievedAt:  e.AchievedAt,
+		}
+	}
+	if len(entries) == 0 {
+		return rankings, nil
+	}
+
+	firstPosition := entries[0].Position
+	firstRank := firstPosition
+	if mode == RankModeStandard {
+		above, err := r.board.CountAbove(ctx, classID, entries[0].Score)
+		if err != nil {
+			return nil, err
+		}
+		firstRank = above + 1
+	}
+	assignRanks(rankings, mode, firstPosition, firstRank)
+	return rankings, nil
+}
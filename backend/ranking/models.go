@@ -1,5 +1,10 @@
 package ranking
 
+import (
+    "encoding/json"
+    "time"
+)
+
 type Character struct {
     CharID    int    `json:"char_id"`
     AccID     int    `json:"acc_id"`
@@ -17,9 +22,11 @@ type Score struct {
 
 type RankingEntry struct {
     Rank         int    `json:"rank"`
+    CharID       int    `json:"char_id"`
     Username     string `json:"username"`
     ClassName    string `json:"class_name"`
     RewardScore  int    `json:"reward_score"`
+    AchievedAt   time.Time `json:"achieved_at"`
 }
 
 type Class struct {
@@ -39,6 +46,120 @@ type Class struct {
 type RankingResponse struct {
     Rankings    []RankingEntry `json:"rankings"`
     TotalCount  int           `json:"total_count"`
-    CurrentPage int           `json:"current_page"`
-    TotalPages  int           `json:"total_pages"`
+    CurrentPage int           `json:"current_page,omitempty"`
+    TotalPages  int           `json:"total_pages,omitempty"`
+    NextCursor  string        `json:"next_cursor,omitempty"`
+    PrevCursor  string        `json:"prev_cursor,omitempty"`
+}
+
+// AroundResponse is a window of the leaderboard centred on one character.
+type AroundResponse struct {
+    Character  RankingEntry   `json:"character"`
+    Rankings   []RankingEntry `json:"rankings"`
+    TotalCount int            `json:"total_count"`
+}
+
+type ScoreEvent struct {
+    EventID     int64           `json:"event_id"`
+    CharID      int             `json:"char_id"`
+    SeasonID    int             `json:"season_id"`
+    RewardScore int             `json:"reward_score"`
+    Delta       int             `json:"delta"`
+    Source      string          `json:"source"`
+    MatchID     string          `json:"match_id,omitempty"`
+    Metadata    json.RawMessage `json:"match_metadata,omitempty"`
+    SubmittedBy int             `json:"submitted_by,omitempty"`
+    CreatedAt   time.Time       `json:"created_at"`
+}
+
+// ScoreSubmission describes where a score came from. Every submission is
+// recorded in the score_events ledger alongside the resulting total.
+type ScoreSubmission struct {
+    Source         string
+    MatchID        string
+    Metadata       json.RawMessage
+    SubmittedBy    int
+    IdempotencyKey string
+}
+
+type ScoreHistoryResponse struct {
+    CharID      int          `json:"char_id"`
+    Events      []ScoreEvent `json:"events"`
+    TotalCount  int          `json:"total_count"`
+    CurrentPage int          `json:"current_page"`
+    TotalPages  int          `json:"total_pages"`
+}
+
+const (
+    SeasonActive   = "active"
+    SeasonArchived = "archived"
+)
+
+type Season struct {
+    ID         int        `json:"id"`
+    Name       string     `json:"name"`
+    Status     string     `json:"status"`
+    StartsAt   time.Time  `json:"starts_at"`
+    EndsAt     *time.Time `json:"ends_at,omitempty"`
+    ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+type RolloverResult struct {
+    Archived *Season `json:"archived"`
+    Current  *Season `json:"current"`
+}
+
+// SearchResult is a ranked character matching a player search.
+type SearchResult struct {
+    CharID           int       `json:"char_id"`
+    Username         string    `json:"username"`
+    ClassName        string    `json:"class_name"`
+    RaceName         string    `json:"race_name"`
+    CombatType       string    `json:"combat_type"`
+    RewardScore      int       `json:"reward_score"`
+    AchievedAt       time.Time `json:"achieved_at"`
+    Rank             int       `json:"rank"`
+    AccountCreatedAt time.Time `json:"account_created_at"`
+    Relevance        float64   `json:"relevance"`
+}
+
+type SearchResponse struct {
+    Results     []SearchResult `json:"results"`
+    TotalCount  int            `json:"total_count"`
+    CurrentPage int            `json:"current_page"`
+    TotalPages  int            `json:"total_pages"`
+}
+
+// Suggestion is a username matching an autocomplete prefix, with the account's
+// best ranked character. Rank is left out when the leaderboard cannot be read
+// in time.
+type Suggestion struct {
+    Username    string `json:"username"`
+    CharID      int    `json:"char_id"`
+    ClassName   string `json:"class_name"`
+    RewardScore int    `json:"reward_score"`
+    Rank        int    `json:"rank,omitempty"`
+}
+
+// ScoreUpdate is one item of a score batch.
+type ScoreUpdate struct {
+    IdempotencyKey string          `json:"idempotency_key"`
+    CharID         int             `json:"char_id"`
+    Score          int             `json:"score"`
+    Source         string          `json:"source"`
+    MatchID        string          `json:"match_id"`
+    Metadata       json.RawMessage `json:"match_metadata"`
+}
+
+// ScoreUpdateResult reports what happened to one item of a score batch, in
+// the order the items were submitted.
+type ScoreUpdateResult struct {
+    Index          int    `json:"index"`
+    IdempotencyKey string `json:"idempotency_key"`
+    CharID         int    `json:"char_id"`
+    Status         string `json:"status"`
+    EventID        int64  `json:"event_id,omitempty"`
+    RewardScore    int    `json:"reward_score,omitempty"`
+    Delta          int    `json:"delta,omitempty"`
+    Error          string `json:"error,omitempty"`
 }
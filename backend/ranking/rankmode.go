@@ -0,0 +1,129 @@
+package ranking
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RankMode selects how tied scores are ranked. Whatever the mode, rows are
+// always listed in the same order: highest score first, then whoever reached
+// that score earliest, then the lower char_id.
+type RankMode string
+
+const (
+	// RankModeRow gives every character a distinct rank, breaking ties by the
+	// listing order (1, 2, 3, 4).
+	RankModeRow RankMode = "row"
+	// RankModeStandard gives tied characters the same rank and skips the
+	// ranks they use up (1, 2, 2, 4).
+	RankModeStandard RankMode = "standard"
+	// RankModeDense gives tied characters the same rank without gaps
+	// (1, 2, 2, 3).
+	RankModeDense RankMode = "dense"
+)
+
+var ErrInvalidRankMode = errors.New("invalid rank mode")
+
+// ParseRankMode reads a rank_mode parameter. An empty value selects row
+// ranking, which matches the behaviour before rank modes existed.
+func ParseRankMode(value string) (RankMode, error) {
+	switch value {
+	case "", string(RankModeRow):
+		return RankModeRow, nil
+	case string(RankModeStandard), "rank":
+		return RankModeStandard, nil
+	case string(RankModeDense):
+		return RankModeDense, nil
+	}
+	return "", ErrInvalidRankMode
+}
+
+// rankOrder returns the listing order over a table alias.
+func rankOrder(alias string) string {
+	return fmt.Sprintf("%[1]s.reward_score DESC, %[1]s.achieved_at, %[1]s.char_id", alias)
+}
+
+// window returns the window function computing this mode's rank over a table
+// alias, optionally partitioned.
+func (m RankMode) window(alias, partition string) string {
+	over := ""
+	if partition != "" {
+		over = "PARTITION BY " + partition + " "
+	}
+	switch m {
+	case RankModeStandard:
+		return fmt.Sprintf("RANK() OVER (%sORDER BY %s.reward_score DESC)", over, alias)
+	case RankModeDense:
+		return fmt.Sprintf("DENSE_RANK() OVER (%sORDER BY %s.reward_score DESC)", over, alias)
+	}
+	return fmt.Sprintf("ROW_NUMBER() OVER (%sORDER BY %s)", over, rankOrder(alias))
+}
+
+// sortKey holds SQL expressions for a row's reward_score, achieved_at and
+// char_id: either bound parameters or another row's columns.
+type sortKey struct {
+	score, achievedAt, charID string
+}
+
+// paramKey binds a row's sort key from $n, $n+1 and $n+2.
+func paramKey(n int) sortKey {
+	return sortKey{fmt.Sprintf("$%d", n), fmt.Sprintf("$%d", n+1), fmt.Sprintf("$%d", n+2)}
+}
+
+func columnKey(alias string) sortKey {
+	return sortKey{alias + ".reward_score", alias + ".achieved_at", alias + ".char_id"}
+}
+
+// aheadOf matches rows of alias listed before the row with key k.
+func aheadOf(alias string, k sortKey) string {
+	return fmt.Sprintf(
+		"(%[1]s.reward_score > %[2]s OR (%[1]s.reward_score = %[2]s AND (%[1]s.achieved_at < %[3]s OR (%[1]s.achieved_at = %[3]s AND %[1]s.char_id < %[4]s))))",
+		alias, k.score, k.achievedAt, k.charID)
+}
+
+// behind matches rows of alias listed after the row with key k.
+func behind(alias string, k sortKey) string {
+	return fmt.Sprintf(
+		"(%[1]s.reward_score < %[2]s OR (%[1]s.reward_score = %[2]s AND (%[1]s.achieved_at > %[3]s OR (%[1]s.achieved_at = %[3]s AND %[1]s.char_id > %[4]s))))",
+		alias, k.score, k.achievedAt, k.charID)
+}
+
+// rankOf returns a query computing this mode's rank for the row with key k
+// within the set selected by from, which must alias its rows as alias and end
+// in a WHERE clause. Standard and dense ranking only read k.score, so with
+// bound parameters use rankOfArgs to supply exactly the ones referenced.
+func (m RankMode) rankOf(from, alias string, k sortKey) string {
+	switch m {
+	case RankModeStandard:
+		return fmt.Sprintf("SELECT COUNT(*) + 1 FROM %s AND %s.reward_score > %s", from, alias, k.score)
+	case RankModeDense:
+		return fmt.Sprintf("SELECT COUNT(DISTINCT %[2]s.reward_score) + 1 FROM %[1]s AND %[2]s.reward_score > %[3]s", from, alias, k.score)
+	}
+	return fmt.Sprintf("SELECT COUNT(*) + 1 FROM %s AND %s", from, aheadOf(alias, k))
+}
+
+func (m RankMode) rankOfArgs(entry RankingEntry) []interface{} {
+	if m == RankModeRow {
+		return []interface{}{entry.RewardScore, entry.AchievedAt, entry.CharID}
+	}
+	return []interface{}{entry.RewardScore}
+}
+
+// assignRanks fills in ranks for a contiguous run of listed rows, given the
+// position and rank of the first one.
+func assignRanks(entries []RankingEntry, mode RankMode, firstPosition, firstRank int) {
+	for i := range entries {
+		switch {
+		case i == 0:
+			entries[i].Rank = firstRank
+		case mode == RankModeRow:
+			entries[i].Rank = firstRank + i
+		case entries[i].RewardScore == entries[i-1].RewardScore:
+			entries[i].Rank = entries[i-1].Rank
+		case mode == RankModeStandard:
+			entries[i].Rank = firstPosition + i
+		default:
+			entries[i].Rank = entries[i-1].Rank + 1
+		}
+	}
+}
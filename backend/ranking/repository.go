@@ -2,34 +2,116 @@ package ranking
 
 import (
     "database/sql"
+    "encoding/json"
+    "errors"
     "fmt"
     "context"
+    "log"
     "wira-assignment/cache"
+    "wira-assignment/leaderboard"
     "time"
 )
 
+// Score sources recorded in the score_events ledger
+const (
+    ScoreSourceAPI        = "api"
+    ScoreSourceBackfill   = "backfill"
+    ScoreSourceCorrection = "correction"
+)
+
+var (
+    ErrCharacterNotFound = errors.New("character not found")
+    ErrNotRanked         = errors.New("character has no ranked score")
+)
+
+// Cache lifetimes. Live ranking pages default to DefaultRankingsTTL and
+// can be changed with SetRankingsTTL.
+var (
+    DefaultRankingsTTL = cache.TTL{Soft: time.Minute, Hard: 5 * time.Minute}
+    archivedTTL        = cache.TTL{Soft: time.Hour, Hard: 24 * time.Hour}
+    classesTTL         = cache.TTL{Soft: time.Hour, Hard: 24 * time.Hour}
+)
+
 type Repository struct {
-    db *sql.DB
+    db       *sql.DB
+    board    *leaderboard.Engine
+    cacheTTL cache.TTL
+
+    rebuilding     int32
+    refreshPending int32
 }
 
 func NewRepository(db *sql.DB) *Repository {
-    return &Repository{db: db}
+    return &Repository{db: db, cacheTTL: DefaultRankingsTTL}
 }
 
-func (r *Repository) GetRankings(classID, page, limit int, search string) ([]RankingEntry, int, error) {
+// SetRankingsTTL sets how long live ranking pages are served fresh and, once
+// stale, how long they are still served while being refreshed.
+func (r *Repository) SetRankingsTTL(ttl cache.TTL) {
+    r.cacheTTL = ttl
+}
+
+// GetRankings returns one page of the leaderboard for a season. A seasonID of
+// 0 means the active season; archived seasons are served from their frozen
+// standings. Ranks are computed over the class before the search filter is
+// applied, so a search shows each match at its real rank.
+func (r *Repository) GetRankings(seasonID, classID, page, limit int, search string, mode RankMode) ([]RankingEntry, int, error) {
     ctx := context.Background()
+
+    // Active-season pages come straight from the sorted sets when available
+    if r.board != nil && seasonID == 0 && search == "" && mode != RankModeDense {
+        rankings, total, err := r.boardRankings(ctx, classID, page, limit, mode)
+        if err == nil {
+            return rankings, total, nil
+        }
+        r.boardError(err)
+    }
     
-    // Create cache key based on parameters
-    cacheKey := fmt.Sprintf("rankings:%d:%d:%d:%s", classID, page, limit, search)
+    // Create cache key based on parameters. The generation changes whenever
+    // a score in the class does, so a stale page is never read back
+    gen, err := cache.Generation(ctx, rankingsTag(classID))
+    if err != nil {
+        log.Printf("Warning: Failed to read cache generation: %v", err)
+    }
+    cacheKey := fmt.Sprintf("rankings:%d:%d:g%d:%s:%d:%d:%s", seasonID, classID, gen, mode, page, limit, search)
     
-    // Try to get from cache
-    var cachedResult struct {
-        Rankings []RankingEntry
-        Total    int
+    // Archived standings never change; live pages are keyed by generation
+    // so they only need a TTL to bound how long a stale page is served
+    ttl := r.cacheTTL
+    if seasonID > 0 {
+        ttl = archivedTTL
+    }
+    var result rankingsPage
+    err = cache.Fetch(ctx, cacheKey, ttl, &result, func(ctx context.Context) (interface{}, error) {
+        rankings, total, err := r.loadRankings(seasonID, classID, page, limit, search, mode)
+        return rankingsPage{rankings, total}, err
+    })
+    if err != nil {
+        return nil, 0, err
+    }
+    return result.Rankings, result.Total, nil
+}
+
+// rankingsPage is the cached form of a GetRankings page
+type rankingsPage struct {
+    Rankings []RankingEntry
+    Total    int
+}
+
+// loadRankings reads a GetRankings page from the database.
+func (r *Repository) loadRankings(seasonID, classID, page, limit int, search string, mode RankMode) ([]RankingEntry, int, error) {
+    if seasonID > 0 {
+        season, err := r.GetSeason(seasonID)
+        if err != nil {
+            return nil, 0, err
+        }
+        if season.Status == SeasonArchived {
+            return r.getArchivedRankings(seasonID, classID, page, limit, search, mode)
+        }
     }
-    err := cache.Get(ctx, cacheKey, &cachedResult)
-    if err == nil {
-        return cachedResult.Rankings, cachedResult.Total, nil
+
+    if search != "" {
+        return r.searchRankings(liveSource, classID, page, limit, search, mode)
     }
 
     offset := (page - 1) * limit
@@ -38,11 +120,12 @@ func (r *Repository) GetRankings(classID, page, limit int, search string) ([]Ran
     query := `
         WITH RankedScores AS (
             SELECT 
-                ROW_NUMBER() OVER (ORDER BY s.reward_score DESC) as rank,
+                ` + mode.window("s", "") + ` as rank,
+                s.char_id,
                 u.username,
                 c.name as class_name,
                 s.reward_score,
-                COUNT(*) OVER() as total_count
+                s.achieved_at
             FROM scores s
             JOIN characters ch ON s.char_id = ch.char_id
             JOIN accounts u ON ch.acc_id = u.acc_id
@@ -59,17 +142,16 @@ func (r *Repository) GetRankings(classID, page, limit int, search string) ([]Ran
         argCount++
     }
 
-    // Add search condition if search is provided
-    if search != "" {
-        query += fmt.Sprintf(" AND LOWER(u.username) LIKE LOWER($%d)", argCount)
-        args = append(args, "%"+search+"%")
-        argCount++
-    }
-
     query += `
         )
-        SELECT rank, username, class_name, reward_score, total_count
-        FROM RankedScores
+        SELECT rank, char_id, username, class_name, reward_score, achieved_at,
+               COUNT(*) OVER() as total_count
+        FROM RankedScores rs
+        WHERE 1=1
+    `
+
+    query += `
+        ORDER BY ` + rankOrder("rs") + `
         LIMIT $` + fmt.Sprint(argCount) + ` OFFSET $` + fmt.Sprint(argCount+1) + `
     `
     args = append(args, limit, offset)
@@ -85,27 +167,83 @@ func (r *Repository) GetRankings(classID, page, limit int, search string) ([]Ran
     var totalCount int
     for rows.Next() {
         var entry RankingEntry
-        err := rows.Scan(&entry.Rank, &entry.Username, &entry.ClassName, &entry.RewardScore, &totalCount)
+        err := rows.Scan(&entry.Rank, &entry.CharID, &entry.Username, &entry.ClassName, &entry.RewardScore, &entry.AchievedAt, &totalCount)
         if err != nil {
             return nil, 0, fmt.Errorf("error scanning ranking entry: %v", err)
         }
         rankings = append(rankings, entry)
     }
 
-    // Cache the results for 1 minute
-    result := struct {
-        Rankings []RankingEntry
-        Total    int
-    }{
-        Rankings: rankings,
-        Total:    totalCount,
+    return rankings, totalCount, nil
+}
+
+// GetRankingsAround returns a character's entry on the active leaderboard
+// together with up to radius entries above and below it. A classID of 0 uses
+// the global leaderboard.
+func (r *Repository) GetRankingsAround(classID, charID, radius int, mode RankMode) (*AroundResponse, error) {
+    ctx := context.Background()
+
+    if r.board != nil && mode != RankModeDense {
+        around, err := r.boardAround(ctx, classID, charID, radius, mode)
+        if err == nil || err == ErrNotRanked {
+            return around, err
+        }
+        r.boardError(err)
     }
-    err = cache.Set(ctx, cacheKey, result, time.Minute)
+
+    query := `
+        WITH RankedScores AS (
+            SELECT 
+                ` + RankModeRow.window("s", "") + ` as position,
+                ` + mode.window("s", "") + ` as rank,
+                s.char_id,
+                u.username,
+                c.name as class_name,
+                s.reward_score,
+                s.achieved_at,
+                COUNT(*) OVER() as total_count
+            FROM scores s
+            JOIN characters ch ON s.char_id = ch.char_id
+            JOIN accounts u ON ch.acc_id = u.acc_id
+            JOIN classes c ON ch.class_id = c.id
+            WHERE ($2 = 0 OR ch.class_id = $2)
+        ),
+        Target AS (
+            SELECT position FROM RankedScores WHERE char_id = $1
+        )
+        SELECT rs.rank, rs.char_id, rs.username, rs.class_name, rs.reward_score, rs.achieved_at, rs.total_count
+        FROM RankedScores rs, Target t
+        WHERE rs.position BETWEEN t.position - $3 AND t.position + $3
+        ORDER BY rs.position
+    `
+    rows, err := r.db.Query(query, charID, classID, radius)
     if err != nil {
-        fmt.Printf("Warning: Failed to cache rankings: %v\n", err)
+        return nil, fmt.Errorf("error querying rankings around character: %v", err)
     }
+    defer rows.Close()
 
-    return rankings, totalCount, nil
+    around := &AroundResponse{Rankings: []RankingEntry{}}
+    found := false
+    for rows.Next() {
+        var entry RankingEntry
+        err := rows.Scan(&entry.Rank, &entry.CharID, &entry.Username, &entry.ClassName, &entry.RewardScore, &entry.AchievedAt, &around.TotalCount)
+        if err != nil {
+            return nil, fmt.Errorf("error scanning ranking entry: %v", err)
+        }
+        if entry.CharID == charID {
+            around.Character = entry
+            found = true
+        }
+        around.Rankings = append(around.Rankings, entry)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("error reading rankings: %v", err)
+    }
+    if !found {
+        return nil, ErrNotRanked
+    }
+
+    return around, nil
 }
 
 func (r *Repository) GetClassIDByName(className string) (int, error) {
@@ -121,15 +259,14 @@ func (r *Repository) GetClassIDByName(className string) (int, error) {
 }
 
 func (r *Repository) GetClasses() ([]Class, error) {
-    ctx := context.Background()
-    
-    // Try to get from cache
     var classes []Class
-    err := cache.Get(ctx, "classes", &classes)
-    if err == nil {
-        return classes, nil
-    }
+    err := cache.Fetch(context.Background(), "classes", classesTTL, &classes, func(ctx context.Context) (interface{}, error) {
+        return r.loadClasses()
+    })
+    return classes, err
+}
 
+func (r *Repository) loadClasses() ([]Class, error) {
     query := `
         SELECT c.id, c.race_id, r.name as race_name, c.name, c.title, 
                c.description, c.combat_type, c.damage, c.defense, 
@@ -143,7 +280,7 @@ func (r *Repository) GetClasses() ([]Class, error) {
     }
     defer rows.Close()
 
-    classes = []Class{}
+    classes := []Class{}
     for rows.Next() {
         var class Class
         err := rows.Scan(
@@ -157,12 +294,6 @@ func (r *Repository) GetClasses() ([]Class, error) {
         classes = append(classes, class)
     }
 
-    // Cache the result for 1 hour
-    err = cache.Set(ctx, "classes", classes, time.Hour)
-    if err != nil {
-        fmt.Printf("Warning: Failed to cache classes: %v\n", err)
-    }
-
     return classes, nil
 }
 
@@ -203,7 +334,8 @@ func (r *Repository) CreateCharacter(userID int, classID int) error {
         return fmt.Errorf("invalid class ID")
     }
 
-    // Create the character
+    // Create the character. It has no score yet, so no ranking page changes
+    // and there is nothing to invalidate until its first submission
     query := `
         INSERT INTO characters (acc_id, class_id)
         VALUES ($1, $2)
@@ -216,17 +348,238 @@ func (r *Repository) CreateCharacter(userID int, classID int) error {
     return nil
 }
 
-func (r *Repository) UpdateScore(charID int, score int) error {
+// UpdateScore records a score submission in the score_events ledger and
+// moves the character's current total in scores to the submitted value.
+func (r *Repository) UpdateScore(charID int, score int, sub ScoreSubmission) (*ScoreEvent, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return nil, fmt.Errorf("error starting transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    seasonID, err := lockActiveSeason(tx)
+    if err != nil {
+        return nil, err
+    }
+
+    event, member, err := applyScore(tx, seasonID, charID, score, sub)
+    if err != nil {
+        return nil, err
+    }
+
+    if err = tx.Commit(); err != nil {
+        return nil, fmt.Errorf("error committing score: %v", err)
+    }
+
+    r.scoresChanged(seasonID, []leaderboard.Member{member})
+    return event, nil
+}
+
+// lockActiveSeason holds the active season for the duration of a submission
+// so a rollover cannot archive it underneath us.
+func lockActiveSeason(tx *sql.Tx) (int, error) {
+    var seasonID int
+    err := tx.QueryRow("SELECT season_id FROM seasons WHERE status = 'active' FOR SHARE").Scan(&seasonID)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return 0, ErrNoActiveSeason
+        }
+        return 0, fmt.Errorf("error reading active season: %v", err)
+    }
+    return seasonID, nil
+}
+
+// applyScore records one submission inside tx and returns the ledger entry
+// and the character's new leaderboard member. A failed ledger insert is
+// wrapped, so a reused idempotency key can be told apart by its *pq.Error.
+func applyScore(tx *sql.Tx, seasonID, charID, score int, sub ScoreSubmission) (*ScoreEvent, leaderboard.Member, error) {
+    if sub.Source == "" {
+        sub.Source = ScoreSourceAPI
+    }
+
+    // Lock the character so concurrent submissions compute their deltas in order
+    member := leaderboard.Member{CharID: charID, Score: score}
+    err := tx.QueryRow(`
+        SELECT ch.class_id, u.username, c.name
+        FROM characters ch
+        JOIN accounts u ON ch.acc_id = u.acc_id
+        JOIN classes c ON ch.class_id = c.id
+        WHERE ch.char_id = $1
+        FOR UPDATE OF ch
+    `, charID).Scan(&member.ClassID, &member.Username, &member.ClassName)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, member, ErrCharacterNotFound
+        }
+        return nil, member, fmt.Errorf("error locking character: %v", err)
+    }
+
+    var previous int
+    err = tx.QueryRow("SELECT reward_score FROM scores WHERE char_id = $1 AND season_id = $2", charID, seasonID).Scan(&previous)
+    if err != nil && err != sql.ErrNoRows {
+        return nil, member, fmt.Errorf("error reading current score: %v", err)
+    }
+
+    event := &ScoreEvent{
+        CharID:      charID,
+        SeasonID:    seasonID,
+        RewardScore: score,
+        Delta:       score - previous,
+        Source:      sub.Source,
+        MatchID:     sub.MatchID,
+        Metadata:    sub.Metadata,
+        SubmittedBy: sub.SubmittedBy,
+    }
+
+    var metadata interface{}
+    if len(sub.Metadata) > 0 {
+        metadata = string(sub.Metadata)
+    }
+    err = tx.QueryRow(`
+        INSERT INTO score_events (char_id, season_id, reward_score, delta, source, match_id, match_metadata, submitted_by, idempotency_key)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, 0), NULLIF($9, ''))
+        RETURNING event_id, created_at
+    `, charID, seasonID, score, event.Delta, sub.Source, sub.MatchID, metadata, sub.SubmittedBy, sub.IdempotencyKey).Scan(&event.EventID, &event.CreatedAt)
+    if err != nil {
+        return nil, member, fmt.Errorf("error recording score event: %w", err)
+    }
+
+    // achieved_at only moves when the score changes, so resubmitting the same
+    // score does not cost a character its place among ties
     query := `
-        INSERT INTO scores (char_id, reward_score)
-        VALUES ($1, $2)
+        INSERT INTO scores (char_id, season_id, reward_score, updated_at, achieved_at)
+        VALUES ($1, $2, $3, $4, $4)
         ON CONFLICT (char_id) DO UPDATE
-        SET reward_score = $2, updated_at = CURRENT_TIMESTAMP
+        SET season_id = $2, reward_score = $3, updated_at = $4,
+            achieved_at = CASE WHEN scores.reward_score = $3 THEN scores.achieved_at ELSE $4 END
+        RETURNING achieved_at
     `
-    _, err := r.db.Exec(query, charID, score)
+    err = tx.QueryRow(query, charID, seasonID, score, event.CreatedAt).Scan(&member.AchievedAt)
     if err != nil {
-        return fmt.Errorf("error updating score: %v", err)
+        return nil, member, fmt.Errorf("error updating score: %v", err)
     }
 
-    return nil
+    return event, member, nil
+}
+
+// scoresChanged pushes committed scores to the leaderboard and drops the
+// cached ranking pages they appear on.
+func (r *Repository) scoresChanged(seasonID int, members []leaderboard.Member) {
+    ctx := context.Background()
+    if r.board != nil {
+        for _, member := range members {
+            if err := r.board.Update(ctx, member); err != nil {
+                log.Printf("Warning: Failed to update leaderboard: %v", err)
+                r.invalidateLeaderboard()
+                break
+            }
+        }
+    }
+
+    // Pages of other classes keep their cache
+    tags := []string{rankingsTag(0)}
+    seen := map[int]bool{}
+    for _, member := range members {
+        if !seen[member.ClassID] {
+            seen[member.ClassID] = true
+            tags = append(tags, rankingsTag(member.ClassID))
+        }
+    }
+    if err := cache.BumpGeneration(ctx, tags...); err != nil {
+        log.Printf("Warning: Failed to invalidate rankings cache: %v", err)
+    }
+}
+
+// rankingsTag is the cache generation tag for a class's ranking pages; class
+// 0 is the all-classes listing.
+func rankingsTag(classID int) string {
+    if classID == 0 {
+        return "rankings:all"
+    }
+    return fmt.Sprintf("rankings:class:%d", classID)
+}
+
+// invalidateAllRankings bumps every ranking tag, for changes such as a
+// season rollover that touch every class at once.
+func (r *Repository) invalidateAllRankings(ctx context.Context) error {
+    rows, err := r.db.QueryContext(ctx, "SELECT id FROM classes")
+    if err != nil {
+        return fmt.Errorf("error querying classes: %v", err)
+    }
+    defer rows.Close()
+
+    tags := []string{rankingsTag(0)}
+    for rows.Next() {
+        var classID int
+        if err := rows.Scan(&classID); err != nil {
+            return fmt.Errorf("error scanning class: %v", err)
+        }
+        tags = append(tags, rankingsTag(classID))
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    return cache.BumpGeneration(ctx, tags...)
+}
+
+// GetScoreHistory returns a character's ledger entries, newest first. A zero
+// from or to leaves that end of the time range open.
+func (r *Repository) GetScoreHistory(charID int, from, to time.Time, page, limit int) ([]ScoreEvent, int, error) {
+    var exists bool
+    err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM characters WHERE char_id = $1)", charID).Scan(&exists)
+    if err != nil {
+        return nil, 0, fmt.Errorf("error checking character existence: %v", err)
+    }
+    if !exists {
+        return nil, 0, ErrCharacterNotFound
+    }
+
+    query := `
+        SELECT event_id, char_id, COALESCE(season_id, 0), reward_score, delta, source,
+               COALESCE(match_id, ''), match_metadata, COALESCE(submitted_by, 0), created_at,
+               COUNT(*) OVER() as total_count
+        FROM score_events
+        WHERE char_id = $1
+    `
+    args := []interface{}{charID}
+    argCount := 2
+    if !from.IsZero() {
+        query += fmt.Sprintf(" AND created_at >= $%d", argCount)
+        args = append(args, from)
+        argCount++
+    }
+    if !to.IsZero() {
+        query += fmt.Sprintf(" AND created_at < $%d", argCount)
+        args = append(args, to)
+        argCount++
+    }
+    query += fmt.Sprintf(" ORDER BY created_at DESC, event_id DESC LIMIT $%d OFFSET $%d", argCount, argCount+1)
+    args = append(args, limit, (page-1)*limit)
+
+    rows, err := r.db.Query(query, args...)
+    if err != nil {
+        return nil, 0, fmt.Errorf("error querying score history: %v", err)
+    }
+    defer rows.Close()
+
+    events := []ScoreEvent{}
+    var totalCount int
+    for rows.Next() {
+        var event ScoreEvent
+        var metadata []byte
+        err := rows.Scan(&event.EventID, &event.CharID, &event.SeasonID, &event.RewardScore, &event.Delta, &event.Source,
+            &event.MatchID, &metadata, &event.SubmittedBy, &event.CreatedAt, &totalCount)
+        if err != nil {
+            return nil, 0, fmt.Errorf("error scanning score event: %v", err)
+        }
+        if len(metadata) > 0 {
+            event.Metadata = json.RawMessage(metadata)
+        }
+        events = append(events, event)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, 0, fmt.Errorf("error reading score history: %v", err)
+    }
+
+    return events, totalCount, nil
 }
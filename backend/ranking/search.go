@@ -0,0 +1,249 @@
+package ranking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wira-assignment/cache"
+)
+
+// Username match modes for player search
+const (
+	MatchPrefix    = "prefix"
+	MatchSubstring = "substring"
+	MatchFuzzy     = "fuzzy"
+)
+
+var ErrInvalidMatch = errors.New("invalid match mode")
+
+// SearchFilter holds player search criteria. Zero values leave a criterion
+// unset.
+type SearchFilter struct {
+	Username      string
+	Match         string
+	ClassID       int
+	Race          string
+	CombatType    string
+	MinScore      *int
+	MaxScore      *int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Page          int
+	Limit         int
+	Mode          RankMode
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchPlayers finds ranked characters on the active leaderboard. With a
+// username, results are ordered by relevance: exact matches first, then
+// prefix, then substring, then trigram similarity. Each result carries the
+// character's rank on the global leaderboard.
+func (r *Repository) SearchPlayers(f SearchFilter) ([]SearchResult, int, error) {
+	where := " WHERE 1=1"
+	var args []interface{}
+	argCount := 1
+	add := func(cond string, value interface{}) {
+		where += " AND " + fmt.Sprintf(cond, argCount)
+		args = append(args, value)
+		argCount++
+	}
+
+	relevance := "0::float8"
+	if f.Username != "" {
+		name := strings.ToLower(f.Username)
+		n := argCount
+		args = append(args, name, escapeLike(name))
+		argCount += 2
+
+		switch f.Match {
+		case MatchPrefix:
+			where += fmt.Sprintf(" AND LOWER(u.username) LIKE $%d || '%%'", n+1)
+		case MatchSubstring:
+			where += fmt.Sprintf(" AND LOWER(u.username) LIKE '%%' || $%d || '%%'", n+1)
+		case MatchFuzzy, "":
+			where += fmt.Sprintf(" AND (LOWER(u.username) LIKE '%%' || $%[2]d || '%%' OR LOWER(u.username) %% $%[1]d)", n, n+1)
+		default:
+			return nil, 0, ErrInvalidMatch
+		}
+
+		relevance = fmt.Sprintf(`
+			CASE
+				WHEN LOWER(u.username) = $%[1]d THEN 3
+				WHEN LOWER(u.username) LIKE $%[2]d || '%%' THEN 2
+				WHEN LOWER(u.username) LIKE '%%' || $%[2]d || '%%' THEN 1
+				ELSE 0
+			END + similarity(LOWER(u.username), $%[1]d)`, n, n+1)
+	}
+
+	if f.ClassID > 0 {
+		add("ch.class_id = $%d", f.ClassID)
+	}
+	if f.Race != "" {
+		add("LOWER(rc.name) = LOWER($%d)", f.Race)
+	}
+	if f.CombatType != "" {
+		add("c.combat_type = UPPER($%d)", f.CombatType)
+	}
+	if f.MinScore != nil {
+		add("s.reward_score >= $%d", *f.MinScore)
+	}
+	if f.MaxScore != nil {
+		add("s.reward_score <= $%d", *f.MaxScore)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("u.created_at >= $%d", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("u.created_at < $%d", f.CreatedBefore)
+	}
+
+	// Ranks are only computed for the page being returned
+	query := `
+		SELECT p.char_id, p.username, p.class_name, p.race_name, p.combat_type,
+		       p.reward_score, p.achieved_at, p.account_created_at, p.relevance, p.total_count,
+		       (` + f.Mode.rankOf(liveSource+" b WHERE 1=1", "b", columnKey("p")) + `) as rank
+		FROM (
+			SELECT s.char_id, u.username, c.name as class_name, rc.name as race_name, c.combat_type,
+			       s.reward_score, s.achieved_at, u.created_at as account_created_at,
+			       ` + relevance + ` as relevance,
+			       COUNT(*) OVER() as total_count
+			FROM scores s
+			JOIN characters ch ON s.char_id = ch.char_id
+			JOIN accounts u ON ch.acc_id = u.acc_id
+			JOIN classes c ON ch.class_id = c.id
+			JOIN races rc ON c.race_id = rc.id
+			` + where + `
+			ORDER BY relevance DESC, ` + rankOrder("s") + `
+			LIMIT $` + fmt.Sprint(argCount) + ` OFFSET $` + fmt.Sprint(argCount+1) + `
+		) p
+		ORDER BY p.relevance DESC, ` + rankOrder("p")
+	args = append(args, f.Limit, (f.Page-1)*f.Limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error searching players: %v", err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	var totalCount int
+	for rows.Next() {
+		var res SearchResult
+		err := rows.Scan(&res.CharID, &res.Username, &res.ClassName, &res.RaceName, &res.CombatType,
+			&res.RewardScore, &res.AchievedAt, &res.AccountCreatedAt, &res.Relevance, &totalCount, &res.Rank)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning search result: %v", err)
+		}
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading search results: %v", err)
+	}
+
+	return results, totalCount, nil
+}
+
+// suggestTimeout bounds an autocomplete lookup; a slow suggestion is useless
+// once the next keystroke arrives.
+const suggestTimeout = 250 * time.Millisecond
+
+// SuggestPlayers returns up to limit usernames starting with prefix, each with
+// the account's best character on the active leaderboard and its global rank.
+// Accounts without a ranked character are skipped.
+func (r *Repository) SuggestPlayers(ctx context.Context, prefix string, limit int) ([]Suggestion, error) {
+	prefix = strings.ToLower(prefix)
+	gen, _ := cache.Generation(ctx, rankingsTag(0))
+	cacheKey := fmt.Sprintf("suggest:g%d:%d:%s", gen, limit, prefix)
+
+	var suggestions []Suggestion
+	if err := cache.Get(ctx, cacheKey, &suggestions); err == nil {
+		return suggestions, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, suggestTimeout)
+	defer cancel()
+
+	// With the board loaded ranks are read from Redis instead of counted
+	suggestions, err := r.suggest(ctx, prefix, limit, r.board != nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.board != nil {
+		if err := r.rankSuggestions(ctx, suggestions); err != nil {
+			// Most of the deadline may be spent, so rather than counting
+			// ranks in Postgres the suggestions go out without them and
+			// are not cached
+			r.boardError(err)
+			return suggestions, nil
+		}
+	}
+
+	cache.Set(ctx, cacheKey, suggestions, 15*time.Second)
+	return suggestions, nil
+}
+
+func (r *Repository) suggest(ctx context.Context, prefix string, limit int, useBoard bool) ([]Suggestion, error) {
+	rank := "0"
+	if !useBoard {
+		rank = "(" + RankModeRow.rankOf(liveSource+" b WHERE 1=1", "b", columnKey("best")) + ")"
+	}
+
+	// The prefix index is walked in username order, so short prefixes stop
+	// after the first few ranked accounts
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT u.username, best.char_id, best.class_name, best.reward_score, `+rank+`
+		FROM accounts u
+		CROSS JOIN LATERAL (
+			SELECT s.char_id, s.reward_score, s.achieved_at, c.name as class_name
+			FROM characters ch
+			JOIN scores s ON s.char_id = ch.char_id
+			JOIN classes c ON ch.class_id = c.id
+			WHERE ch.acc_id = u.acc_id
+			ORDER BY `+rankOrder("s")+`
+			LIMIT 1
+		) best
+		WHERE LOWER(u.username) COLLATE "C" LIKE $1
+		ORDER BY LOWER(u.username) COLLATE "C"
+		LIMIT $2
+	`, escapeLike(prefix)+"%", limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching suggestions: %v", err)
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var s Suggestion
+		if err := rows.Scan(&s.Username, &s.CharID, &s.ClassName, &s.RewardScore, &s.Rank); err != nil {
+			return nil, fmt.Errorf("error scanning suggestion: %v", err)
+		}
+		suggestions = append(suggestions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading suggestions: %v", err)
+	}
+
+	return suggestions, nil
+}
+
+// rankSuggestions fills in global ranks from the leaderboard.
+func (r *Repository) rankSuggestions(ctx context.Context, suggestions []Suggestion) error {
+	charIDs := make([]int, len(suggestions))
+	for i, s := range suggestions {
+		charIDs[i] = s.CharID
+	}
+	positions, err := r.board.Positions(ctx, 0, charIDs)
+	if err != nil {
+		return err
+	}
+	for i := range suggestions {
+		suggestions[i].Rank = positions[i]
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package ranking
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrSeasonNotFound = errors.New("season not found")
+	ErrNoActiveSeason = errors.New("no active season")
+	ErrRolloverNotDue = errors.New("active season has not ended yet")
+)
+
+const seasonColumns = "season_id, name, status, starts_at, ends_at, archived_at"
+
+func scanSeason(row interface{ Scan(...interface{}) error }) (*Season, error) {
+	var season Season
+	var endsAt, archivedAt sql.NullTime
+	err := row.Scan(&season.ID, &season.Name, &season.Status, &season.StartsAt, &endsAt, &archivedAt)
+	if err != nil {
+		return nil, err
+	}
+	if endsAt.Valid {
+		season.EndsAt = &endsAt.Time
+	}
+	if archivedAt.Valid {
+		season.ArchivedAt = &archivedAt.Time
+	}
+	return &season, nil
+}
+
+func (r *Repository) GetActiveSeason() (*Season, error) {
+	season, err := scanSeason(r.db.QueryRow("SELECT " + seasonColumns + " FROM seasons WHERE status = 'active'"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoActiveSeason
+		}
+		return nil, fmt.Errorf("error querying active season: %v", err)
+	}
+	return season, nil
+}
+
+func (r *Repository) GetSeason(seasonID int) (*Season, error) {
+	season, err := scanSeason(r.db.QueryRow("SELECT "+seasonColumns+" FROM seasons WHERE season_id = $1", seasonID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSeasonNotFound
+		}
+		return nil, fmt.Errorf("error querying season: %v", err)
+	}
+	return season, nil
+}
+
+// resolveSeason maps a requested season ID to a season, treating 0 as the
+// active season.
+func (r *Repository) resolveSeason(seasonID int) (*Season, error) {
+	if seasonID == 0 {
+		return r.GetActiveSeason()
+	}
+	return r.GetSeason(seasonID)
+}
+
+func (r *Repository) ListSeasons() ([]Season, error) {
+	rows, err := r.db.Query("SELECT " + seasonColumns + " FROM seasons ORDER BY starts_at DESC, season_id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("error querying seasons: %v", err)
+	}
+	defer rows.Close()
+
+	seasons := []Season{}
+	for rows.Next() {
+		season, err := scanSeason(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning season: %v", err)
+		}
+		seasons = append(seasons, *season)
+	}
+	return seasons, rows.Err()
+}
+
+// RolloverSeason freezes the active season's final standings into
+// season_standings, archives it and opens a new season with an empty
+// leaderboard. An empty name defaults to "Season N"; a zero endsAt leaves the
+// new season open until the next manual rollover.
+func (r *Repository) RolloverSeason(name string, endsAt time.Time) (*RolloverResult, error) {
+	return r.rollover(name, endsAt, false)
+}
+
+// RolloverDueSeason rolls the active season over if its scheduled end has
+// passed. The next season runs for the same length as the one it replaces.
+// It returns ErrRolloverNotDue when there is nothing to do.
+func (r *Repository) RolloverDueSeason() (*RolloverResult, error) {
+	return r.rollover("", time.Time{}, true)
+}
+
+func (r *Repository) rollover(name string, endsAt time.Time, onlyIfDue bool) (*RolloverResult, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Blocks score submissions, which take a share lock on the active season
+	active, err := scanSeason(tx.QueryRow("SELECT " + seasonColumns + " FROM seasons WHERE status = 'active' FOR UPDATE"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoActiveSeason
+		}
+		return nil, fmt.Errorf("error locking active season: %v", err)
+	}
+
+	now := time.Now()
+	if onlyIfDue {
+		if active.EndsAt == nil || active.EndsAt.After(now) {
+			return nil, ErrRolloverNotDue
+		}
+		endsAt = now.Add(active.EndsAt.Sub(active.StartsAt))
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO season_standings (season_id, char_id, class_id, rank, class_rank, username, class_name, reward_score, achieved_at)
+		SELECT
+			$1,
+			s.char_id,
+			ch.class_id,
+			`+RankModeRow.window("s", "")+`,
+			`+RankModeRow.window("s", "ch.class_id")+`,
+			u.username,
+			c.name,
+			s.reward_score,
+			s.achieved_at
+		FROM scores s
+		JOIN characters ch ON s.char_id = ch.char_id
+		JOIN accounts u ON ch.acc_id = u.acc_id
+		JOIN classes c ON ch.class_id = c.id
+		WHERE s.season_id = $1
+	`, active.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error archiving standings: %v", err)
+	}
+
+	archived, err := scanSeason(tx.QueryRow(`
+		UPDATE seasons
+		SET status = 'archived', archived_at = $2, ends_at = LEAST(COALESCE(ends_at, $2), $2)
+		WHERE season_id = $1
+		RETURNING `+seasonColumns, active.ID, now))
+	if err != nil {
+		return nil, fmt.Errorf("error archiving season: %v", err)
+	}
+
+	if _, err = tx.Exec("DELETE FROM scores WHERE season_id = $1", active.ID); err != nil {
+		return nil, fmt.Errorf("error resetting scores: %v", err)
+	}
+
+	if name == "" {
+		var count int
+		if err = tx.QueryRow("SELECT COUNT(*) FROM seasons").Scan(&count); err != nil {
+			return nil, fmt.Errorf("error counting seasons: %v", err)
+		}
+		name = fmt.Sprintf("Season %d", count+1)
+	}
+
+	var nextEnd interface{}
+	if !endsAt.IsZero() {
+		nextEnd = endsAt
+	}
+	current, err := scanSeason(tx.QueryRow(`
+		INSERT INTO seasons (name, status, starts_at, ends_at)
+		VALUES ($1, 'active', $2, $3)
+		RETURNING `+seasonColumns, name, now, nextEnd))
+	if err != nil {
+		return nil, fmt.Errorf("error creating season: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing rollover: %v", err)
+	}
+
+	if err := r.invalidateAllRankings(context.Background()); err != nil {
+		log.Printf("Warning: Failed to invalidate rankings cache: %v", err)
+	}
+	r.invalidateLeaderboard()
+
+	return &RolloverResult{Archived: archived, Current: current}, nil
+}
+
+// archivedSource selects a season's frozen standings with the same columns
+// as the live leaderboard. Standings archived before achieved_at was recorded
+// fall back to ordering by char_id alone, which is how they were ranked.
+func archivedSource(seasonID int) string {
+	return fmt.Sprintf(`(
+		SELECT char_id, class_id, username, class_name, reward_score,
+		       COALESCE(achieved_at, 'epoch'::timestamptz) AS achieved_at
+		FROM season_standings
+		WHERE season_id = %d
+	)`, seasonID)
+}
+
+func (r *Repository) getArchivedRankings(seasonID, classID, page, limit int, search string, mode RankMode) ([]RankingEntry, int, error) {
+	if search != "" {
+		return r.searchRankings(archivedSource(seasonID), classID, page, limit, search, mode)
+	}
+
+	query := `
+		WITH RankedScores AS (
+			SELECT ` + mode.window("ss", "") + ` as rank,
+			       ss.char_id, ss.username, ss.class_name, ss.reward_score, ss.achieved_at
+			FROM ` + archivedSource(seasonID) + ` ss
+			WHERE 1=1
+	`
+	var args []interface{}
+	argCount := 1
+	if classID > 0 {
+		query += fmt.Sprintf(" AND ss.class_id = $%d", argCount)
+		args = append(args, classID)
+		argCount++
+	}
+	query += `
+		)
+		SELECT rank, char_id, username, class_name, reward_score, achieved_at,
+		       COUNT(*) OVER() as total_count
+		FROM RankedScores rs
+		WHERE 1=1
+	`
+	query += " ORDER BY " + rankOrder("rs") + fmt.Sprintf(" LIMIT $%d OFFSET $%d", argCount, argCount+1)
+	args = append(args, limit, (page-1)*limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying archived rankings: %v", err)
+	}
+	defer rows.Close()
+
+	var rankings []RankingEntry
+	var totalCount int
+	for rows.Next() {
+		var entry RankingEntry
+		if err := rows.Scan(&entry.Rank, &entry.CharID, &entry.Username, &entry.ClassName, &entry.RewardScore, &entry.AchievedAt, &totalCount); err != nil {
+			return nil, 0, fmt.Errorf("error scanning ranking entry: %v", err)
+		}
+		rankings = append(rankings, entry)
+	}
+
+	return rankings, totalCount, rows.Err()
+}
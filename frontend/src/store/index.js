@@ -133,9 +133,13 @@ export default createStore({
       }
     },
     
-    async verify2FALogin({ commit, dispatch }, { username, code }) {
+    async verify2FALogin({ commit, dispatch }, { challenge, code }) {
       try {
-        const response = await api.post('/api/auth/2fa/login/verify', { username, code })
+        // Authenticator codes are digits only; anything else is a recovery code
+        const body = /^\d+$/.test(code.trim())
+          ? { challenge, code: code.trim() }
+          : { challenge, recovery_code: code.trim() }
+        const response = await api.post('/api/auth/2fa/login/verify', body)
         const { token, user, sessionID } = response.data
         
         commit('setUser', user)
@@ -146,7 +150,7 @@ export default createStore({
         dispatch('startSessionCheck')
         
         router.push('/')
-        return true
+        return response.data
       } catch (error) {
         console.error('2FA login failed:', error)
         throw error